@@ -0,0 +1,994 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// annotation marks the struct types to generate
+const annotation = "//bencode:generate"
+
+// value kinds of field which are encoded and decoded without reflection
+const (
+	kindFallback = iota // encoded and decoded by bencode package
+	kindString
+	kindBytes
+	kindArray // byte array
+	kindInt
+	kindUint
+	kindStruct    // generated struct
+	kindStructPtr // pointer to generated struct
+)
+
+// fieldType is the resolved type of struct field
+type fieldType struct {
+	kind    int
+	name    string // type in Go syntax
+	bitSize int    // bit size of int and uint kinds
+}
+
+// step is a field on the way from the generated struct to its field
+type step struct {
+	name string
+	ptr  bool // embedded pointer
+	typ  string
+}
+
+// genField is a field encoded as dict key
+type genField struct {
+	key       string
+	path      []step
+	typ       fieldType
+	tagged    bool // the key is named by tag
+	omitEmpty bool
+	expr      ast.Expr
+}
+
+func (f genField) access() string {
+	names := make([]string, len(f.path)+1)
+	names[0] = "v"
+	for i, s := range f.path {
+		names[i+1] = s.name
+	}
+	return strings.Join(names, ".")
+}
+
+type generator struct {
+	pkg   string
+	types map[string]*ast.TypeSpec
+	// methods of local types
+	methods map[string]map[string]bool
+	gen     map[string]bool
+	buf     bytes.Buffer
+}
+
+func newGenerator(pkg string, files []*ast.File) *generator {
+	g := &generator{
+		pkg:     pkg,
+		types:   make(map[string]*ast.TypeSpec),
+		methods: make(map[string]map[string]bool),
+		gen:     make(map[string]bool),
+	}
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			switch decl := decl.(type) {
+			case *ast.GenDecl:
+				if decl.Tok != token.TYPE {
+					continue
+				}
+				for _, spec := range decl.Specs {
+					spec := spec.(*ast.TypeSpec)
+					g.types[spec.Name.Name] = spec
+					if hasAnnotation(decl.Doc) || hasAnnotation(spec.Doc) {
+						g.gen[spec.Name.Name] = true
+					}
+				}
+			case *ast.FuncDecl:
+				if decl.Recv == nil || len(decl.Recv.List) == 0 {
+					continue
+				}
+				recv := decl.Recv.List[0].Type
+				if star, ok := recv.(*ast.StarExpr); ok {
+					recv = star.X
+				}
+				if id, ok := recv.(*ast.Ident); ok {
+					if g.methods[id.Name] == nil {
+						g.methods[id.Name] = make(map[string]bool)
+					}
+					g.methods[id.Name][decl.Name.Name] = true
+				}
+			}
+		}
+	}
+	return g
+}
+
+func hasAnnotation(doc *ast.CommentGroup) bool {
+	if doc == nil {
+		return false
+	}
+	for _, c := range doc.List {
+		if strings.TrimSpace(c.Text) == annotation {
+			return true
+		}
+	}
+	return false
+}
+
+// setTypes replaces the annotated types by names
+func (g *generator) setTypes(names []string) error {
+	g.gen = make(map[string]bool)
+	for _, name := range names {
+		if _, ok := g.structType(name); !ok {
+			return fmt.Errorf("%s is not a struct type", name)
+		}
+		g.gen[name] = true
+	}
+	return nil
+}
+
+// structType returns the struct of local type name
+func (g *generator) structType(name string) (*ast.StructType, bool) {
+	spec, ok := g.types[name]
+	if !ok {
+		return nil, false
+	}
+	st, ok := spec.Type.(*ast.StructType)
+	return st, ok
+}
+
+// hasCodec reports whether the local type marshals itself, types with
+// text or binary codec are encoded by the package as byte strings
+func (g *generator) hasCodec(name string) bool {
+	m := g.methods[name]
+	return m["MarshalBencode"] || m["UnmarshalBencode"] ||
+		m["MarshalText"] || m["UnmarshalText"] ||
+		m["MarshalBinary"] || m["UnmarshalBinary"]
+}
+
+var basicTypes = map[string]fieldType{
+	"string": {kind: kindString},
+	"int":    {kind: kindInt},
+	"int8":   {kind: kindInt, bitSize: 8},
+	"int16":  {kind: kindInt, bitSize: 16},
+	"int32":  {kind: kindInt, bitSize: 32},
+	"rune":   {kind: kindInt, bitSize: 32},
+	"int64":  {kind: kindInt, bitSize: 64},
+	"uint":   {kind: kindUint},
+	"uint8":  {kind: kindUint, bitSize: 8},
+	"byte":   {kind: kindUint, bitSize: 8},
+	"uint16": {kind: kindUint, bitSize: 16},
+	"uint32": {kind: kindUint, bitSize: 32},
+	"uint64": {kind: kindUint, bitSize: 64},
+}
+
+func isByte(expr ast.Expr) bool {
+	id, ok := expr.(*ast.Ident)
+	return ok && (id.Name == "byte" || id.Name == "uint8")
+}
+
+// resolve returns the field type of expr
+func (g *generator) resolve(expr ast.Expr) fieldType {
+	name := exprString(expr)
+	switch expr := expr.(type) {
+	case *ast.Ident:
+		if ft, ok := basicTypes[expr.Name]; ok {
+			ft.name = name
+			return ft
+		}
+		spec, ok := g.types[expr.Name]
+		if !ok || g.hasCodec(expr.Name) {
+			return fieldType{name: name}
+		}
+		if g.gen[expr.Name] {
+			return fieldType{kind: kindStruct, name: name}
+		}
+		ft := g.resolve(spec.Type)
+		if ft.kind == kindStruct || ft.kind == kindStructPtr {
+			return fieldType{name: name}
+		}
+		ft.name = name
+		return ft
+	case *ast.ArrayType:
+		if !isByte(expr.Elt) {
+			break
+		}
+		if expr.Len == nil {
+			return fieldType{kind: kindBytes, name: name}
+		}
+		return fieldType{kind: kindArray, name: name}
+	case *ast.StarExpr:
+		if id, ok := expr.X.(*ast.Ident); ok && g.gen[id.Name] {
+			return fieldType{kind: kindStructPtr, name: id.Name}
+		}
+	}
+	return fieldType{name: name}
+}
+
+// nonEmptyCond returns the condition of non-empty value of expr for
+// omitempty, empty string means the value is never empty, ok is false
+// when the type of expr can not be resolved
+func (g *generator) nonEmptyCond(expr ast.Expr, access string) (string, bool) {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		switch {
+		case e.Name == "string":
+			return "len(" + access + ") != 0", true
+		case e.Name == "bool":
+			return access, true
+		case e.Name == "error":
+			return access + " != nil", true
+		}
+		if _, ok := basicTypes[e.Name]; ok {
+			return access + " != 0", true
+		}
+		if strings.HasPrefix(e.Name, "float") || e.Name == "uintptr" {
+			return access + " != 0", true
+		}
+		if spec, ok := g.types[e.Name]; ok {
+			return g.nonEmptyCond(spec.Type, access)
+		}
+	case *ast.ArrayType, *ast.MapType:
+		return "len(" + access + ") != 0", true
+	case *ast.StarExpr, *ast.InterfaceType, *ast.FuncType, *ast.ChanType:
+		return access + " != nil", true
+	case *ast.StructType:
+		return "", true
+	}
+	return "", false
+}
+
+// embeddedType returns the name and the struct of embedded field type
+func (g *generator) embeddedType(expr ast.Expr) (string, bool, *ast.StructType) {
+	ptr := false
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+		ptr = true
+	}
+	id, ok := expr.(*ast.Ident)
+	if !ok {
+		return "", ptr, nil
+	}
+	name := id.Name
+	for {
+		spec, ok := g.types[id.Name]
+		if !ok {
+			return name, ptr, nil
+		}
+		if st, ok := spec.Type.(*ast.StructType); ok {
+			return name, ptr, st
+		}
+		// alias or named type of other local type
+		next, ok := spec.Type.(*ast.Ident)
+		if !ok {
+			return name, ptr, nil
+		}
+		id = next
+	}
+}
+
+func fieldTag(field *ast.Field) string {
+	if field.Tag == nil {
+		return ""
+	}
+	tag, _ := strconv.Unquote(field.Tag.Value)
+	return reflect.StructTag(tag).Get("bencode")
+}
+
+// embedded reports whether the field is an embedded struct whose
+// fields are promoted into its parent dict, the same as isEmbedded
+func (g *generator) embedded(field *ast.Field) (bool, error) {
+	if len(field.Names) > 0 {
+		return false, nil
+	}
+	tag := fieldTag(field)
+	if tag == "-" {
+		return false, nil
+	}
+	if name, _ := parseTag(tag); len(name) > 0 {
+		return false, nil
+	}
+	name, _, st := g.embeddedType(field.Type)
+	if st != nil {
+		return true, nil
+	}
+	if _, ok := g.types[name]; ok || basicTypes[name].kind != kindFallback {
+		return false, nil
+	}
+	return false, fmt.Errorf("can not resolve embedded type %s", exprString(field.Type))
+}
+
+// structField is a field of struct declaration
+type structField struct {
+	name  string
+	field *ast.Field
+}
+
+func structFields(st *ast.StructType) []structField {
+	var ret []structField
+	for _, field := range st.Fields.List {
+		if len(field.Names) == 0 {
+			ret = append(ret, structField{name: embeddedName(field.Type), field: field})
+			continue
+		}
+		for _, id := range field.Names {
+			ret = append(ret, structField{name: id.Name, field: field})
+		}
+	}
+	return ret
+}
+
+// embeddedName returns the field name of embedded type expr
+func embeddedName(expr ast.Expr) string {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	switch expr := expr.(type) {
+	case *ast.Ident:
+		return expr.Name
+	case *ast.SelectorExpr:
+		return expr.Sel.Name
+	}
+	return ""
+}
+
+// typeFields returns the encoded fields of st sorted by key, the fields
+// of embedded structs are resolved in the same way as bencode package,
+// a shallower field hides the deeper ones, at the same depth a tagged
+// field hides the untagged ones, and the key is dropped when it is
+// still ambiguous
+func (g *generator) typeFields(name string, st *ast.StructType) ([]genField, error) {
+	type embedded struct {
+		name string
+		st   *ast.StructType
+		path []step
+	}
+	var fields []genField
+	next := []embedded{{name: name, st: st}}
+	var count, nextCount map[string]int
+	// the struct embedded at a shallower depth is walked only once
+	visited := make(map[string]bool)
+	for len(next) > 0 {
+		current := next
+		next = nil
+		count, nextCount = nextCount, make(map[string]int)
+		for _, e := range current {
+			if visited[e.name] {
+				continue
+			}
+			visited[e.name] = true
+			for _, sf := range structFields(e.st) {
+				isEmbedded, err := g.embedded(sf.field)
+				if err != nil {
+					return nil, err
+				}
+				if isEmbedded {
+					typ, ptr, inner := g.embeddedType(sf.field.Type)
+					nextCount[typ]++
+					if nextCount[typ] == 1 {
+						path := append(append([]step{}, e.path...), step{name: sf.name, ptr: ptr, typ: typ})
+						next = append(next, embedded{name: typ, st: inner, path: path})
+					}
+					continue
+				}
+				if !ast.IsExported(sf.name) {
+					continue
+				}
+				tag := fieldTag(sf.field)
+				if tag == "-" {
+					continue
+				}
+				key, opts := parseTag(tag)
+				tagged := len(key) > 0
+				if !tagged {
+					key = strings.ToLower(sf.name)
+				}
+				f := genField{
+					key:       key,
+					path:      append(append([]step{}, e.path...), step{name: sf.name}),
+					typ:       g.resolve(sf.field.Type),
+					tagged:    tagged,
+					omitEmpty: opts.contains("omitempty"),
+					expr:      sf.field.Type,
+				}
+				fields = append(fields, f)
+				if count[e.name] > 1 {
+					// the same struct is embedded more than once at
+					// this depth, so its fields are ambiguous
+					fields = append(fields, f)
+				}
+			}
+		}
+	}
+	sort.SliceStable(fields, func(i, j int) bool {
+		a, b := fields[i], fields[j]
+		if a.key != b.key {
+			return a.key < b.key
+		}
+		if len(a.path) != len(b.path) {
+			return len(a.path) < len(b.path)
+		}
+		return a.tagged && !b.tagged
+	})
+	ret := fields[:0]
+	for i := 0; i < len(fields); {
+		j := i + 1
+		for j < len(fields) && fields[j].key == fields[i].key {
+			j++
+		}
+		group := fields[i:j]
+		if len(group) == 1 || len(group[0].path) < len(group[1].path) ||
+			group[0].tagged != group[1].tagged {
+			ret = append(ret, group[0])
+		}
+		i = j
+	}
+	return ret, nil
+}
+
+func (g *generator) printf(format string, a ...interface{}) {
+	fmt.Fprintf(&g.buf, format, a...)
+}
+
+// generate returns the formatted source of all generated types
+func (g *generator) generate() ([]byte, error) {
+	var names []string
+	for name := range g.gen {
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no type is annotated by %s", annotation)
+	}
+	sort.Strings(names)
+	g.printf("// Code generated by bencodegen. DO NOT EDIT.\n\n")
+	g.printf("package %s\n\n", g.pkg)
+	g.printf("import (\n\"bytes\"\n\"fmt\"\n\"reflect\"\n\"strconv\"\n\n\"github.com/lwch/bencode\"\n)\n")
+	for _, name := range names {
+		st, _ := g.structType(name)
+		err := g.generateType(name, st)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	g.buf.WriteString(helpers)
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %v", err)
+	}
+	return src, nil
+}
+
+func (g *generator) generateType(name string, st *ast.StructType) error {
+	fields, err := g.typeFields(name, st)
+	if err != nil {
+		return err
+	}
+
+	g.printf("\n// MarshalBencode encode %s into bencode dict\n", name)
+	g.printf("func (v %s) MarshalBencode() ([]byte, error) {\n", name)
+	g.printf("var buf bytes.Buffer\nerr := v.encodeBencode(&buf)\n")
+	g.printf("if err != nil {\nreturn nil, err\n}\nreturn buf.Bytes(), nil\n}\n")
+
+	g.printf("\nfunc (v *%s) encodeBencode(buf *bytes.Buffer) error {\n", name)
+	g.printf("buf.WriteByte('d')\n")
+	for _, f := range fields {
+		err = g.encodeField(f)
+		if err != nil {
+			return err
+		}
+	}
+	g.printf("buf.WriteByte('e')\nreturn nil\n}\n")
+
+	g.printf("\n// UnmarshalBencode decode bencode dict into %s\n", name)
+	g.printf("func (v *%s) UnmarshalBencode(data []byte) error {\n", name)
+	g.printf("return v.UnmarshalBencodeOptions(data, bencode.DecodeOptions{})\n}\n")
+
+	g.printf("\n// UnmarshalBencodeOptions decode bencode dict into %s with the options of bencode.Decoder\n", name)
+	g.printf("func (v *%s) UnmarshalBencodeOptions(data []byte, opts bencode.DecodeOptions) error {\n", name)
+	g.printf("s := bencodeScanner{data: data, opts: opts}\n")
+	g.printf("err := v.decodeBencode(&s)\nif err != nil {\nreturn err\n}\n")
+	g.printf("return s.end()\n}\n")
+
+	g.printf("\nfunc (v *%s) decodeBencode(s *bencodeScanner) error {\n", name)
+	g.printf("err := s.readDictStart((*%s)(nil))\nif err != nil {\nreturn err\n}\n", name)
+	g.printf("var prev []byte\n")
+	g.printf("for first := true; s.more(); first = false {\n")
+	g.printf("key, err := s.readKey(prev, first)\nif err != nil {\nreturn err\n}\n")
+	g.printf("prev = key\n")
+	g.printf("switch string(key) {\n")
+	for _, f := range fields {
+		g.printf("case %s:\n", strconv.Quote(f.key))
+		g.decodeField(f)
+	}
+	g.printf("default:\nerr = s.skip()\n}\n")
+	g.printf("if err != nil {\nreturn err\n}\n}\n")
+	g.printf("return s.readEnd()\n}\n")
+	return nil
+}
+
+func (g *generator) encodeField(f genField) error {
+	access := f.access()
+	var conds []string
+	for i, s := range f.path[:len(f.path)-1] {
+		if s.ptr {
+			conds = append(conds, genField{path: f.path[:i+1]}.access()+" != nil")
+		}
+	}
+	if f.omitEmpty {
+		cond, ok := g.nonEmptyCond(f.expr, access)
+		if !ok {
+			// the type of other package is checked by reflection
+			cond = "!bencodeIsEmpty(" + access + ")"
+		}
+		if len(cond) > 0 {
+			conds = append(conds, cond)
+		}
+	}
+	if len(conds) > 0 {
+		g.printf("if %s {\n", strings.Join(conds, " && "))
+	}
+	g.printf("buf.WriteString(%s)\n", strconv.Quote(strconv.Itoa(len(f.key))+":"+f.key))
+	switch f.typ.kind {
+	case kindString:
+		if f.typ.name == "string" {
+			g.printf("bencodeWriteString(buf, %s)\n", access)
+		} else {
+			g.printf("bencodeWriteString(buf, string(%s))\n", access)
+		}
+	case kindBytes:
+		g.printf("bencodeWriteBytes(buf, %s)\n", access)
+	case kindArray:
+		g.printf("bencodeWriteBytes(buf, %s[:])\n", access)
+	case kindInt:
+		g.printf("bencodeWriteInt(buf, int64(%s))\n", access)
+	case kindUint:
+		g.printf("bencodeWriteUint(buf, uint64(%s))\n", access)
+	case kindStruct:
+		g.printf("if err := %s.encodeBencode(buf); err != nil {\nreturn err\n}\n", access)
+	case kindStructPtr:
+		if !f.omitEmpty {
+			// as bencode.Encode, nil is not encoded unless omitempty
+			g.printf("if %s == nil {\nreturn fmt.Errorf(\"not supported nil value of %%q\", %s)\n}\n",
+				access, strconv.Quote(f.key))
+		}
+		g.printf("if err := %s.encodeBencode(buf); err != nil {\nreturn err\n}\n", access)
+	default:
+		g.printf("if err := bencodeWriteValue(buf, %s); err != nil {\nreturn err\n}\n", access)
+	}
+	if len(conds) > 0 {
+		g.printf("}\n")
+	}
+	return nil
+}
+
+func (g *generator) decodeField(f genField) {
+	for i, s := range f.path[:len(f.path)-1] {
+		if s.ptr {
+			access := genField{path: f.path[:i+1]}.access()
+			g.printf("if %s == nil {\n%s = new(%s)\n}\n", access, access, s.typ)
+		}
+	}
+	access := f.access()
+	switch f.typ.kind {
+	case kindArray:
+		g.printf("err = s.readArray(%s[:], (*%s)(nil))\n", access, f.typ.name)
+	case kindString, kindBytes:
+		g.printf("var str []byte\n")
+		g.printf("if str, err = s.readString((*%s)(nil)); err == nil {\n", f.typ.name)
+		switch f.typ.kind {
+		case kindBytes:
+			g.printf("%s = %s(s.keepBytes(str))\n", access, f.typ.name)
+		default:
+			g.printf("%s = %s(str)\n", access, f.typ.name)
+		}
+		g.printf("}\n")
+	case kindInt:
+		g.printf("var n int64\n")
+		g.printf("if n, err = s.readInt(%d, (*%s)(nil)); err == nil {\n", f.typ.bitSize, f.typ.name)
+		g.printf("%s = %s(n)\n}\n", access, f.typ.name)
+	case kindUint:
+		g.printf("var n uint64\n")
+		g.printf("if n, err = s.readUint(%d, (*%s)(nil)); err == nil {\n", f.typ.bitSize, f.typ.name)
+		g.printf("%s = %s(n)\n}\n", access, f.typ.name)
+	case kindStruct:
+		g.printf("err = %s.decodeBencode(s)\n", access)
+	case kindStructPtr:
+		g.printf("if %s == nil {\n%s = new(%s)\n}\n", access, access, f.typ.name)
+		g.printf("err = %s.decodeBencode(s)\n", access)
+	default:
+		// the raw value refers to data, the Decoder copies what it keeps
+		// unless NoCopy
+		g.printf("var raw []byte\n")
+		g.printf("if raw, err = s.readRaw(); err == nil {\n")
+		g.printf("err = s.opts.NewDecoder(raw).Decode(&%s)\n}\n", access)
+	}
+}
+
+func exprString(expr ast.Expr) string {
+	var buf bytes.Buffer
+	format.Node(&buf, token.NewFileSet(), expr)
+	return buf.String()
+}
+
+// parseTag splits a bencode tag into its key name and options
+func parseTag(tag string) (string, tagOptions) {
+	if idx := strings.Index(tag, ","); idx != -1 {
+		return tag[:idx], tagOptions(tag[idx+1:])
+	}
+	return tag, ""
+}
+
+type tagOptions string
+
+func (opts tagOptions) contains(name string) bool {
+	for _, opt := range strings.Split(string(opts), ",") {
+		if opt == name {
+			return true
+		}
+	}
+	return false
+}
+
+// helpers are shared by the generated types of package
+const helpers = `
+func bencodeWriteString(buf *bytes.Buffer, str string) {
+	buf.WriteString(strconv.Itoa(len(str)))
+	buf.WriteByte(':')
+	buf.WriteString(str)
+}
+
+func bencodeWriteBytes(buf *bytes.Buffer, data []byte) {
+	buf.WriteString(strconv.Itoa(len(data)))
+	buf.WriteByte(':')
+	buf.Write(data)
+}
+
+func bencodeWriteInt(buf *bytes.Buffer, n int64) {
+	buf.WriteByte('i')
+	buf.WriteString(strconv.FormatInt(n, 10))
+	buf.WriteByte('e')
+}
+
+func bencodeWriteUint(buf *bytes.Buffer, n uint64) {
+	buf.WriteByte('i')
+	buf.WriteString(strconv.FormatUint(n, 10))
+	buf.WriteByte('e')
+}
+
+// bencodeIsEmpty reports whether v is empty for omitempty
+func bencodeIsEmpty(v interface{}) bool {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	case reflect.Bool:
+		return !rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return rv.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return rv.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return rv.IsNil()
+	}
+	return false
+}
+
+func bencodeWriteValue(buf *bytes.Buffer, v interface{}) error {
+	data, err := bencode.Encode(v)
+	if err != nil {
+		return err
+	}
+	buf.Write(data)
+	return nil
+}
+
+// bencodeMaxDepth limits the nesting depth as bencode.Decode does
+const bencodeMaxDepth = 10000
+
+// bencodeScanner reads the input of UnmarshalBencodeOptions in place, it
+// checks the syntax as bencode.Decoder with the same options
+type bencodeScanner struct {
+	data  []byte
+	off   int
+	depth int
+	opts  bencode.DecodeOptions
+}
+
+// bencodeLevel is a list or dict skipped by bencodeScanner
+type bencodeLevel struct {
+	dict  bool
+	first bool
+	prev  []byte
+}
+
+func (s *bencodeScanner) syntaxError(msg string) error {
+	if s.off >= len(s.data) {
+		msg = "unexpected EOF"
+	}
+	return bencode.NewSyntaxError(msg, int64(s.off))
+}
+
+// typeError returns the error of value what which can not be decoded
+// into the value pointed by v
+func (s *bencodeScanner) typeError(what string, v interface{}) error {
+	return &bencode.UnmarshalTypeError{
+		Value:  what,
+		Type:   reflect.TypeOf(v).Elem(),
+		Offset: int64(s.off),
+	}
+}
+
+// valueError returns the error of next value which can not be decoded
+// into the value pointed by v
+func (s *bencodeScanner) valueError(v interface{}) error {
+	if s.off >= len(s.data) {
+		return s.invalidValue()
+	}
+	switch ch := s.data[s.off]; {
+	case ch == 'i':
+		return s.typeError("number", v)
+	case ch == 'l':
+		return s.typeError("list", v)
+	case ch == 'd':
+		return s.typeError("dict", v)
+	case ch >= '0' && ch <= '9':
+		return s.typeError("string", v)
+	}
+	return s.invalidValue()
+}
+
+// invalidValue returns the error of next byte which is not the start of value
+func (s *bencodeScanner) invalidValue() error {
+	if s.off >= len(s.data) {
+		return s.syntaxError("")
+	}
+	return s.syntaxError(fmt.Sprintf("invalid character %q at start of value", s.data[s.off]))
+}
+
+func (s *bencodeScanner) more() bool {
+	return s.off < len(s.data) && s.data[s.off] != 'e'
+}
+
+// end checks there is no data after the value
+func (s *bencodeScanner) end() error {
+	if s.off != len(s.data) {
+		return s.syntaxError("unexpected data after value")
+	}
+	return nil
+}
+
+// enter is called when start reading a list or dict
+func (s *bencodeScanner) enter() error {
+	s.depth++
+	if s.depth > bencodeMaxDepth {
+		return &bencode.LimitError{Limit: "depth", Max: bencodeMaxDepth, Offset: int64(s.off)}
+	}
+	return nil
+}
+
+// readDictStart reads the start of dict decoded into the value pointed by v
+func (s *bencodeScanner) readDictStart(v interface{}) error {
+	if s.off < len(s.data) && s.data[s.off] == 'd' {
+		s.off++
+		return s.enter()
+	}
+	return s.valueError(v)
+}
+
+// readEnd reads the end of list or dict
+func (s *bencodeScanner) readEnd() error {
+	if s.off < len(s.data) && s.data[s.off] == 'e' {
+		s.off++
+		s.depth--
+		return nil
+	}
+	return s.syntaxError("")
+}
+
+// readBytes reads a string, the returned bytes refer to data
+func (s *bencodeScanner) readBytes() ([]byte, error) {
+	start := s.off
+	size := 0
+	for s.off < len(s.data) && s.data[s.off] >= '0' && s.data[s.off] <= '9' {
+		if size > len(s.data) {
+			return nil, s.syntaxError("string size too large")
+		}
+		size = size*10 + int(s.data[s.off]-'0')
+		s.off++
+	}
+	if s.off == start || s.off >= len(s.data) || s.data[s.off] != ':' {
+		return nil, s.syntaxError("invalid string size")
+	}
+	if !s.opts.AllowNonCanonical && s.data[start] == '0' && s.off-start > 1 {
+		return nil, s.syntaxError("leading zero in string size")
+	}
+	s.off++
+	if size > len(s.data)-s.off {
+		s.off = len(s.data)
+		return nil, s.syntaxError("")
+	}
+	s.off += size
+	return s.data[s.off-size : s.off : s.off], nil
+}
+
+// readString reads a string decoded into the value pointed by v
+func (s *bencodeScanner) readString(v interface{}) ([]byte, error) {
+	if s.off < len(s.data) && s.data[s.off] >= '0' && s.data[s.off] <= '9' {
+		return s.readBytes()
+	}
+	return nil, s.valueError(v)
+}
+
+// readArray reads a string into byte array dst pointed by v, the string
+// must be as long as the array
+func (s *bencodeScanner) readArray(dst []byte, v interface{}) error {
+	str, err := s.readString(v)
+	if err != nil {
+		return err
+	}
+	if len(str) != len(dst) {
+		return s.typeError("string of length "+strconv.Itoa(len(str)), v)
+	}
+	copy(dst, str)
+	return nil
+}
+
+// keepBytes returns str kept by []byte field, it is copied unless NoCopy
+func (s *bencodeScanner) keepBytes(str []byte) []byte {
+	if s.opts.NoCopy {
+		return str
+	}
+	return append(make([]byte, 0, len(str)), str...)
+}
+
+// readKey reads a dict key, which must be sorted after prev unless
+// AllowNonCanonical
+func (s *bencodeScanner) readKey(prev []byte, first bool) ([]byte, error) {
+	if s.data[s.off] < '0' || s.data[s.off] > '9' {
+		return nil, s.syntaxError("dict key is not a string")
+	}
+	key, err := s.readBytes()
+	if err != nil {
+		return nil, err
+	}
+	if !first && !s.opts.AllowNonCanonical {
+		switch bytes.Compare(prev, key) {
+		case 0:
+			return nil, s.syntaxError("duplicate dict key")
+		case 1:
+			return nil, s.syntaxError("dict key is not sorted")
+		}
+	}
+	return key, nil
+}
+
+// readNumber reads a number and returns its text
+func (s *bencodeScanner) readNumber() ([]byte, error) {
+	start := s.off + 1
+	size := bytes.IndexByte(s.data[start:], 'e')
+	if size < 0 {
+		s.off = len(s.data)
+		return nil, s.syntaxError("")
+	}
+	text := s.data[start : start+size]
+	digits := text
+	if len(digits) > 0 && digits[0] == '-' {
+		digits = digits[1:]
+	}
+	if len(digits) == 0 {
+		return nil, s.syntaxError("invalid number")
+	}
+	for _, ch := range digits {
+		if ch < '0' || ch > '9' {
+			return nil, s.syntaxError("invalid character in number")
+		}
+	}
+	if !s.opts.AllowNonCanonical && digits[0] == '0' && len(text) > 1 {
+		return nil, s.syntaxError("leading zero or negative zero in number")
+	}
+	s.off = start + size + 1
+	return text, nil
+}
+
+func (s *bencodeScanner) readInt(bitSize int, v interface{}) (int64, error) {
+	if s.off >= len(s.data) || s.data[s.off] != 'i' {
+		return 0, s.valueError(v)
+	}
+	text, err := s.readNumber()
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseInt(string(text), 10, bitSize)
+	if err != nil {
+		return 0, s.typeError("number "+string(text), v)
+	}
+	return n, nil
+}
+
+func (s *bencodeScanner) readUint(bitSize int, v interface{}) (uint64, error) {
+	if s.off >= len(s.data) || s.data[s.off] != 'i' {
+		return 0, s.valueError(v)
+	}
+	text, err := s.readNumber()
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseUint(string(text), 10, bitSize)
+	if err != nil {
+		return 0, s.typeError("number "+string(text), v)
+	}
+	return n, nil
+}
+
+// skip reads and discards the next value
+func (s *bencodeScanner) skip() error {
+	var levels [8]bencodeLevel
+	stack := levels[:0]
+	for {
+		if len(stack) > 0 {
+			top := &stack[len(stack)-1]
+			if !s.more() {
+				err := s.readEnd()
+				if err != nil {
+					return err
+				}
+				stack = stack[:len(stack)-1]
+				if len(stack) == 0 {
+					return nil
+				}
+				continue
+			}
+			if top.dict {
+				key, err := s.readKey(top.prev, top.first)
+				if err != nil {
+					return err
+				}
+				top.prev, top.first = key, false
+			}
+		}
+		var err error
+		switch {
+		case s.off >= len(s.data):
+			return s.invalidValue()
+		case s.data[s.off] == 'i':
+			_, err = s.readNumber()
+		case s.data[s.off] == 'l' || s.data[s.off] == 'd':
+			stack = append(stack, bencodeLevel{dict: s.data[s.off] == 'd', first: true})
+			s.off++
+			err = s.enter()
+			if err == nil {
+				continue
+			}
+		case s.data[s.off] >= '0' && s.data[s.off] <= '9':
+			_, err = s.readBytes()
+		default:
+			err = s.invalidValue()
+		}
+		if err != nil {
+			return err
+		}
+		if len(stack) == 0 {
+			return nil
+		}
+	}
+}
+
+// readRaw reads the next value and returns its raw bytes
+func (s *bencodeScanner) readRaw() ([]byte, error) {
+	start := s.off
+	err := s.skip()
+	if err != nil {
+		return nil, err
+	}
+	return s.data[start:s.off:s.off], nil
+}
+`
@@ -0,0 +1,56 @@
+// Package krpc is the DHT messages for testing bencodegen
+package krpc
+
+import "github.com/lwch/bencode"
+
+//go:generate go run github.com/lwch/bencode/cmd/bencodegen
+
+// NodeID is the id of DHT node
+type NodeID [20]byte
+
+// Port is the port of peer
+type Port uint16
+
+// Header is the common keys of messages
+type Header struct {
+	T string `bencode:"t"`
+	Y string `bencode:"y"`
+	V []byte `bencode:"v,omitempty"`
+}
+
+// Args is the arguments of query
+//
+//bencode:generate
+type Args struct {
+	ID          NodeID  `bencode:"id"`
+	Target      *NodeID `bencode:"target,omitempty"`
+	InfoHash    string  `bencode:"info_hash,omitempty"`
+	Port        Port    `bencode:"port,omitempty"`
+	ImpliedPort int     `bencode:"implied_port,omitempty"`
+	Token       string  `bencode:"token,omitempty"`
+}
+
+// Response is the result of query
+//
+//bencode:generate
+type Response struct {
+	ID     NodeID   `bencode:"id"`
+	Nodes  []byte   `bencode:"nodes,omitempty"`
+	Values []string `bencode:"values,omitempty"`
+	Token  string   `bencode:"token,omitempty"`
+}
+
+// Msg is the KRPC message
+//
+//bencode:generate
+type Msg struct {
+	Header
+	Q       string             `bencode:"q,omitempty"`
+	A       *Args              `bencode:"a,omitempty"`
+	R       *Response          `bencode:"r,omitempty"`
+	E       []interface{}      `bencode:"e,omitempty"`
+	IP      bencode.RawMessage `bencode:"ip,omitempty"`
+	Seq     int64              `bencode:",omitempty"`
+	Ignored string             `bencode:"-"`
+	private int
+}
@@ -0,0 +1,697 @@
+// Code generated by bencodegen. DO NOT EDIT.
+
+package krpc
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/lwch/bencode"
+)
+
+// MarshalBencode encode Args into bencode dict
+func (v Args) MarshalBencode() ([]byte, error) {
+	var buf bytes.Buffer
+	err := v.encodeBencode(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (v *Args) encodeBencode(buf *bytes.Buffer) error {
+	buf.WriteByte('d')
+	buf.WriteString("2:id")
+	bencodeWriteBytes(buf, v.ID[:])
+	if v.ImpliedPort != 0 {
+		buf.WriteString("12:implied_port")
+		bencodeWriteInt(buf, int64(v.ImpliedPort))
+	}
+	if len(v.InfoHash) != 0 {
+		buf.WriteString("9:info_hash")
+		bencodeWriteString(buf, v.InfoHash)
+	}
+	if v.Port != 0 {
+		buf.WriteString("4:port")
+		bencodeWriteUint(buf, uint64(v.Port))
+	}
+	if v.Target != nil {
+		buf.WriteString("6:target")
+		if err := bencodeWriteValue(buf, v.Target); err != nil {
+			return err
+		}
+	}
+	if len(v.Token) != 0 {
+		buf.WriteString("5:token")
+		bencodeWriteString(buf, v.Token)
+	}
+	buf.WriteByte('e')
+	return nil
+}
+
+// UnmarshalBencode decode bencode dict into Args
+func (v *Args) UnmarshalBencode(data []byte) error {
+	return v.UnmarshalBencodeOptions(data, bencode.DecodeOptions{})
+}
+
+// UnmarshalBencodeOptions decode bencode dict into Args with the options of bencode.Decoder
+func (v *Args) UnmarshalBencodeOptions(data []byte, opts bencode.DecodeOptions) error {
+	s := bencodeScanner{data: data, opts: opts}
+	err := v.decodeBencode(&s)
+	if err != nil {
+		return err
+	}
+	return s.end()
+}
+
+func (v *Args) decodeBencode(s *bencodeScanner) error {
+	err := s.readDictStart((*Args)(nil))
+	if err != nil {
+		return err
+	}
+	var prev []byte
+	for first := true; s.more(); first = false {
+		key, err := s.readKey(prev, first)
+		if err != nil {
+			return err
+		}
+		prev = key
+		switch string(key) {
+		case "id":
+			err = s.readArray(v.ID[:], (*NodeID)(nil))
+		case "implied_port":
+			var n int64
+			if n, err = s.readInt(0, (*int)(nil)); err == nil {
+				v.ImpliedPort = int(n)
+			}
+		case "info_hash":
+			var str []byte
+			if str, err = s.readString((*string)(nil)); err == nil {
+				v.InfoHash = string(str)
+			}
+		case "port":
+			var n uint64
+			if n, err = s.readUint(16, (*Port)(nil)); err == nil {
+				v.Port = Port(n)
+			}
+		case "target":
+			var raw []byte
+			if raw, err = s.readRaw(); err == nil {
+				err = s.opts.NewDecoder(raw).Decode(&v.Target)
+			}
+		case "token":
+			var str []byte
+			if str, err = s.readString((*string)(nil)); err == nil {
+				v.Token = string(str)
+			}
+		default:
+			err = s.skip()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return s.readEnd()
+}
+
+// MarshalBencode encode Msg into bencode dict
+func (v Msg) MarshalBencode() ([]byte, error) {
+	var buf bytes.Buffer
+	err := v.encodeBencode(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (v *Msg) encodeBencode(buf *bytes.Buffer) error {
+	buf.WriteByte('d')
+	if v.A != nil {
+		buf.WriteString("1:a")
+		if err := v.A.encodeBencode(buf); err != nil {
+			return err
+		}
+	}
+	if len(v.E) != 0 {
+		buf.WriteString("1:e")
+		if err := bencodeWriteValue(buf, v.E); err != nil {
+			return err
+		}
+	}
+	if !bencodeIsEmpty(v.IP) {
+		buf.WriteString("2:ip")
+		if err := bencodeWriteValue(buf, v.IP); err != nil {
+			return err
+		}
+	}
+	if len(v.Q) != 0 {
+		buf.WriteString("1:q")
+		bencodeWriteString(buf, v.Q)
+	}
+	if v.R != nil {
+		buf.WriteString("1:r")
+		if err := v.R.encodeBencode(buf); err != nil {
+			return err
+		}
+	}
+	if v.Seq != 0 {
+		buf.WriteString("3:seq")
+		bencodeWriteInt(buf, int64(v.Seq))
+	}
+	buf.WriteString("1:t")
+	bencodeWriteString(buf, v.Header.T)
+	if len(v.Header.V) != 0 {
+		buf.WriteString("1:v")
+		bencodeWriteBytes(buf, v.Header.V)
+	}
+	buf.WriteString("1:y")
+	bencodeWriteString(buf, v.Header.Y)
+	buf.WriteByte('e')
+	return nil
+}
+
+// UnmarshalBencode decode bencode dict into Msg
+func (v *Msg) UnmarshalBencode(data []byte) error {
+	return v.UnmarshalBencodeOptions(data, bencode.DecodeOptions{})
+}
+
+// UnmarshalBencodeOptions decode bencode dict into Msg with the options of bencode.Decoder
+func (v *Msg) UnmarshalBencodeOptions(data []byte, opts bencode.DecodeOptions) error {
+	s := bencodeScanner{data: data, opts: opts}
+	err := v.decodeBencode(&s)
+	if err != nil {
+		return err
+	}
+	return s.end()
+}
+
+func (v *Msg) decodeBencode(s *bencodeScanner) error {
+	err := s.readDictStart((*Msg)(nil))
+	if err != nil {
+		return err
+	}
+	var prev []byte
+	for first := true; s.more(); first = false {
+		key, err := s.readKey(prev, first)
+		if err != nil {
+			return err
+		}
+		prev = key
+		switch string(key) {
+		case "a":
+			if v.A == nil {
+				v.A = new(Args)
+			}
+			err = v.A.decodeBencode(s)
+		case "e":
+			var raw []byte
+			if raw, err = s.readRaw(); err == nil {
+				err = s.opts.NewDecoder(raw).Decode(&v.E)
+			}
+		case "ip":
+			var raw []byte
+			if raw, err = s.readRaw(); err == nil {
+				err = s.opts.NewDecoder(raw).Decode(&v.IP)
+			}
+		case "q":
+			var str []byte
+			if str, err = s.readString((*string)(nil)); err == nil {
+				v.Q = string(str)
+			}
+		case "r":
+			if v.R == nil {
+				v.R = new(Response)
+			}
+			err = v.R.decodeBencode(s)
+		case "seq":
+			var n int64
+			if n, err = s.readInt(64, (*int64)(nil)); err == nil {
+				v.Seq = int64(n)
+			}
+		case "t":
+			var str []byte
+			if str, err = s.readString((*string)(nil)); err == nil {
+				v.Header.T = string(str)
+			}
+		case "v":
+			var str []byte
+			if str, err = s.readString((*[]byte)(nil)); err == nil {
+				v.Header.V = []byte(s.keepBytes(str))
+			}
+		case "y":
+			var str []byte
+			if str, err = s.readString((*string)(nil)); err == nil {
+				v.Header.Y = string(str)
+			}
+		default:
+			err = s.skip()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return s.readEnd()
+}
+
+// MarshalBencode encode Response into bencode dict
+func (v Response) MarshalBencode() ([]byte, error) {
+	var buf bytes.Buffer
+	err := v.encodeBencode(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (v *Response) encodeBencode(buf *bytes.Buffer) error {
+	buf.WriteByte('d')
+	buf.WriteString("2:id")
+	bencodeWriteBytes(buf, v.ID[:])
+	if len(v.Nodes) != 0 {
+		buf.WriteString("5:nodes")
+		bencodeWriteBytes(buf, v.Nodes)
+	}
+	if len(v.Token) != 0 {
+		buf.WriteString("5:token")
+		bencodeWriteString(buf, v.Token)
+	}
+	if len(v.Values) != 0 {
+		buf.WriteString("6:values")
+		if err := bencodeWriteValue(buf, v.Values); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('e')
+	return nil
+}
+
+// UnmarshalBencode decode bencode dict into Response
+func (v *Response) UnmarshalBencode(data []byte) error {
+	return v.UnmarshalBencodeOptions(data, bencode.DecodeOptions{})
+}
+
+// UnmarshalBencodeOptions decode bencode dict into Response with the options of bencode.Decoder
+func (v *Response) UnmarshalBencodeOptions(data []byte, opts bencode.DecodeOptions) error {
+	s := bencodeScanner{data: data, opts: opts}
+	err := v.decodeBencode(&s)
+	if err != nil {
+		return err
+	}
+	return s.end()
+}
+
+func (v *Response) decodeBencode(s *bencodeScanner) error {
+	err := s.readDictStart((*Response)(nil))
+	if err != nil {
+		return err
+	}
+	var prev []byte
+	for first := true; s.more(); first = false {
+		key, err := s.readKey(prev, first)
+		if err != nil {
+			return err
+		}
+		prev = key
+		switch string(key) {
+		case "id":
+			err = s.readArray(v.ID[:], (*NodeID)(nil))
+		case "nodes":
+			var str []byte
+			if str, err = s.readString((*[]byte)(nil)); err == nil {
+				v.Nodes = []byte(s.keepBytes(str))
+			}
+		case "token":
+			var str []byte
+			if str, err = s.readString((*string)(nil)); err == nil {
+				v.Token = string(str)
+			}
+		case "values":
+			var raw []byte
+			if raw, err = s.readRaw(); err == nil {
+				err = s.opts.NewDecoder(raw).Decode(&v.Values)
+			}
+		default:
+			err = s.skip()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return s.readEnd()
+}
+
+func bencodeWriteString(buf *bytes.Buffer, str string) {
+	buf.WriteString(strconv.Itoa(len(str)))
+	buf.WriteByte(':')
+	buf.WriteString(str)
+}
+
+func bencodeWriteBytes(buf *bytes.Buffer, data []byte) {
+	buf.WriteString(strconv.Itoa(len(data)))
+	buf.WriteByte(':')
+	buf.Write(data)
+}
+
+func bencodeWriteInt(buf *bytes.Buffer, n int64) {
+	buf.WriteByte('i')
+	buf.WriteString(strconv.FormatInt(n, 10))
+	buf.WriteByte('e')
+}
+
+func bencodeWriteUint(buf *bytes.Buffer, n uint64) {
+	buf.WriteByte('i')
+	buf.WriteString(strconv.FormatUint(n, 10))
+	buf.WriteByte('e')
+}
+
+// bencodeIsEmpty reports whether v is empty for omitempty
+func bencodeIsEmpty(v interface{}) bool {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	case reflect.Bool:
+		return !rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return rv.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return rv.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return rv.IsNil()
+	}
+	return false
+}
+
+func bencodeWriteValue(buf *bytes.Buffer, v interface{}) error {
+	data, err := bencode.Encode(v)
+	if err != nil {
+		return err
+	}
+	buf.Write(data)
+	return nil
+}
+
+// bencodeMaxDepth limits the nesting depth as bencode.Decode does
+const bencodeMaxDepth = 10000
+
+// bencodeScanner reads the input of UnmarshalBencodeOptions in place, it
+// checks the syntax as bencode.Decoder with the same options
+type bencodeScanner struct {
+	data  []byte
+	off   int
+	depth int
+	opts  bencode.DecodeOptions
+}
+
+// bencodeLevel is a list or dict skipped by bencodeScanner
+type bencodeLevel struct {
+	dict  bool
+	first bool
+	prev  []byte
+}
+
+func (s *bencodeScanner) syntaxError(msg string) error {
+	if s.off >= len(s.data) {
+		msg = "unexpected EOF"
+	}
+	return bencode.NewSyntaxError(msg, int64(s.off))
+}
+
+// typeError returns the error of value what which can not be decoded
+// into the value pointed by v
+func (s *bencodeScanner) typeError(what string, v interface{}) error {
+	return &bencode.UnmarshalTypeError{
+		Value:  what,
+		Type:   reflect.TypeOf(v).Elem(),
+		Offset: int64(s.off),
+	}
+}
+
+// valueError returns the error of next value which can not be decoded
+// into the value pointed by v
+func (s *bencodeScanner) valueError(v interface{}) error {
+	if s.off >= len(s.data) {
+		return s.invalidValue()
+	}
+	switch ch := s.data[s.off]; {
+	case ch == 'i':
+		return s.typeError("number", v)
+	case ch == 'l':
+		return s.typeError("list", v)
+	case ch == 'd':
+		return s.typeError("dict", v)
+	case ch >= '0' && ch <= '9':
+		return s.typeError("string", v)
+	}
+	return s.invalidValue()
+}
+
+// invalidValue returns the error of next byte which is not the start of value
+func (s *bencodeScanner) invalidValue() error {
+	if s.off >= len(s.data) {
+		return s.syntaxError("")
+	}
+	return s.syntaxError(fmt.Sprintf("invalid character %q at start of value", s.data[s.off]))
+}
+
+func (s *bencodeScanner) more() bool {
+	return s.off < len(s.data) && s.data[s.off] != 'e'
+}
+
+// end checks there is no data after the value
+func (s *bencodeScanner) end() error {
+	if s.off != len(s.data) {
+		return s.syntaxError("unexpected data after value")
+	}
+	return nil
+}
+
+// enter is called when start reading a list or dict
+func (s *bencodeScanner) enter() error {
+	s.depth++
+	if s.depth > bencodeMaxDepth {
+		return &bencode.LimitError{Limit: "depth", Max: bencodeMaxDepth, Offset: int64(s.off)}
+	}
+	return nil
+}
+
+// readDictStart reads the start of dict decoded into the value pointed by v
+func (s *bencodeScanner) readDictStart(v interface{}) error {
+	if s.off < len(s.data) && s.data[s.off] == 'd' {
+		s.off++
+		return s.enter()
+	}
+	return s.valueError(v)
+}
+
+// readEnd reads the end of list or dict
+func (s *bencodeScanner) readEnd() error {
+	if s.off < len(s.data) && s.data[s.off] == 'e' {
+		s.off++
+		s.depth--
+		return nil
+	}
+	return s.syntaxError("")
+}
+
+// readBytes reads a string, the returned bytes refer to data
+func (s *bencodeScanner) readBytes() ([]byte, error) {
+	start := s.off
+	size := 0
+	for s.off < len(s.data) && s.data[s.off] >= '0' && s.data[s.off] <= '9' {
+		if size > len(s.data) {
+			return nil, s.syntaxError("string size too large")
+		}
+		size = size*10 + int(s.data[s.off]-'0')
+		s.off++
+	}
+	if s.off == start || s.off >= len(s.data) || s.data[s.off] != ':' {
+		return nil, s.syntaxError("invalid string size")
+	}
+	if !s.opts.AllowNonCanonical && s.data[start] == '0' && s.off-start > 1 {
+		return nil, s.syntaxError("leading zero in string size")
+	}
+	s.off++
+	if size > len(s.data)-s.off {
+		s.off = len(s.data)
+		return nil, s.syntaxError("")
+	}
+	s.off += size
+	return s.data[s.off-size : s.off : s.off], nil
+}
+
+// readString reads a string decoded into the value pointed by v
+func (s *bencodeScanner) readString(v interface{}) ([]byte, error) {
+	if s.off < len(s.data) && s.data[s.off] >= '0' && s.data[s.off] <= '9' {
+		return s.readBytes()
+	}
+	return nil, s.valueError(v)
+}
+
+// readArray reads a string into byte array dst pointed by v, the string
+// must be as long as the array
+func (s *bencodeScanner) readArray(dst []byte, v interface{}) error {
+	str, err := s.readString(v)
+	if err != nil {
+		return err
+	}
+	if len(str) != len(dst) {
+		return s.typeError("string of length "+strconv.Itoa(len(str)), v)
+	}
+	copy(dst, str)
+	return nil
+}
+
+// keepBytes returns str kept by []byte field, it is copied unless NoCopy
+func (s *bencodeScanner) keepBytes(str []byte) []byte {
+	if s.opts.NoCopy {
+		return str
+	}
+	return append(make([]byte, 0, len(str)), str...)
+}
+
+// readKey reads a dict key, which must be sorted after prev unless
+// AllowNonCanonical
+func (s *bencodeScanner) readKey(prev []byte, first bool) ([]byte, error) {
+	if s.data[s.off] < '0' || s.data[s.off] > '9' {
+		return nil, s.syntaxError("dict key is not a string")
+	}
+	key, err := s.readBytes()
+	if err != nil {
+		return nil, err
+	}
+	if !first && !s.opts.AllowNonCanonical {
+		switch bytes.Compare(prev, key) {
+		case 0:
+			return nil, s.syntaxError("duplicate dict key")
+		case 1:
+			return nil, s.syntaxError("dict key is not sorted")
+		}
+	}
+	return key, nil
+}
+
+// readNumber reads a number and returns its text
+func (s *bencodeScanner) readNumber() ([]byte, error) {
+	start := s.off + 1
+	size := bytes.IndexByte(s.data[start:], 'e')
+	if size < 0 {
+		s.off = len(s.data)
+		return nil, s.syntaxError("")
+	}
+	text := s.data[start : start+size]
+	digits := text
+	if len(digits) > 0 && digits[0] == '-' {
+		digits = digits[1:]
+	}
+	if len(digits) == 0 {
+		return nil, s.syntaxError("invalid number")
+	}
+	for _, ch := range digits {
+		if ch < '0' || ch > '9' {
+			return nil, s.syntaxError("invalid character in number")
+		}
+	}
+	if !s.opts.AllowNonCanonical && digits[0] == '0' && len(text) > 1 {
+		return nil, s.syntaxError("leading zero or negative zero in number")
+	}
+	s.off = start + size + 1
+	return text, nil
+}
+
+func (s *bencodeScanner) readInt(bitSize int, v interface{}) (int64, error) {
+	if s.off >= len(s.data) || s.data[s.off] != 'i' {
+		return 0, s.valueError(v)
+	}
+	text, err := s.readNumber()
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseInt(string(text), 10, bitSize)
+	if err != nil {
+		return 0, s.typeError("number "+string(text), v)
+	}
+	return n, nil
+}
+
+func (s *bencodeScanner) readUint(bitSize int, v interface{}) (uint64, error) {
+	if s.off >= len(s.data) || s.data[s.off] != 'i' {
+		return 0, s.valueError(v)
+	}
+	text, err := s.readNumber()
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseUint(string(text), 10, bitSize)
+	if err != nil {
+		return 0, s.typeError("number "+string(text), v)
+	}
+	return n, nil
+}
+
+// skip reads and discards the next value
+func (s *bencodeScanner) skip() error {
+	var levels [8]bencodeLevel
+	stack := levels[:0]
+	for {
+		if len(stack) > 0 {
+			top := &stack[len(stack)-1]
+			if !s.more() {
+				err := s.readEnd()
+				if err != nil {
+					return err
+				}
+				stack = stack[:len(stack)-1]
+				if len(stack) == 0 {
+					return nil
+				}
+				continue
+			}
+			if top.dict {
+				key, err := s.readKey(top.prev, top.first)
+				if err != nil {
+					return err
+				}
+				top.prev, top.first = key, false
+			}
+		}
+		var err error
+		switch {
+		case s.off >= len(s.data):
+			return s.invalidValue()
+		case s.data[s.off] == 'i':
+			_, err = s.readNumber()
+		case s.data[s.off] == 'l' || s.data[s.off] == 'd':
+			stack = append(stack, bencodeLevel{dict: s.data[s.off] == 'd', first: true})
+			s.off++
+			err = s.enter()
+			if err == nil {
+				continue
+			}
+		case s.data[s.off] >= '0' && s.data[s.off] <= '9':
+			_, err = s.readBytes()
+		default:
+			err = s.invalidValue()
+		}
+		if err != nil {
+			return err
+		}
+		if len(stack) == 0 {
+			return nil
+		}
+	}
+}
+
+// readRaw reads the next value and returns its raw bytes
+func (s *bencodeScanner) readRaw() ([]byte, error) {
+	start := s.off
+	err := s.skip()
+	if err != nil {
+		return nil, err
+	}
+	return s.data[start:s.off:s.off], nil
+}
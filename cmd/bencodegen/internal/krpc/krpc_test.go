@@ -0,0 +1,183 @@
+package krpc
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/lwch/bencode"
+)
+
+// plainArgs is the same as Args without generated methods
+type plainArgs struct {
+	ID          NodeID  `bencode:"id"`
+	Target      *NodeID `bencode:"target,omitempty"`
+	InfoHash    string  `bencode:"info_hash,omitempty"`
+	Port        Port    `bencode:"port,omitempty"`
+	ImpliedPort int     `bencode:"implied_port,omitempty"`
+	Token       string  `bencode:"token,omitempty"`
+}
+
+func TestArgs(t *testing.T) {
+	var target NodeID
+	copy(target[:], "abcdefghij0123456789")
+	for _, args := range []Args{
+		{ID: target},
+		{ID: target, Target: &target, InfoHash: "hash", Port: 6881, ImpliedPort: 1, Token: "tk"},
+	} {
+		data, err := args.MarshalBencode()
+		if err != nil {
+			t.Fatalf("FATAL: marshal: %v", err)
+		}
+		want, err := bencode.Encode(plainArgs(args))
+		if err != nil {
+			t.Fatalf("FATAL: encode: %v", err)
+		}
+		if !bytes.Equal(data, want) {
+			t.Fatalf("unexpected marshaled: %q", data)
+		}
+		var dec Args
+		err = dec.UnmarshalBencode(data)
+		if err != nil {
+			t.Fatalf("FATAL: unmarshal: %v", err)
+		}
+		if !reflect.DeepEqual(dec, args) {
+			t.Fatalf("unexpected unmarshaled: %+v", dec)
+		}
+	}
+
+	// unknown keys are skipped
+	var args Args
+	err := args.UnmarshalBencode([]byte("d9:info_hash1:a4:porti1e7:unknownld1:ai1e1:bl0:eeee"))
+	if err != nil {
+		t.Fatalf("FATAL: unmarshal: %v", err)
+	}
+	if args.InfoHash != "a" || args.Port != 1 {
+		t.Fatalf("unexpected unmarshaled: %+v", args)
+	}
+
+	for _, str := range []string{"d4:porti65536ee", "d4:porti-1ee", "d2:idi1ee", "le",
+		"d2:id3:abce", "d2:id21:abcdefghij0123456789xe"} {
+		var args Args
+		var e *bencode.UnmarshalTypeError
+		err := args.UnmarshalBencode([]byte(str))
+		if !errors.As(err, &e) {
+			t.Fatalf("unexpected error of %q: %v", str, err)
+		}
+	}
+	// non-canonical or trailing data is rejected
+	for _, str := range []string{"d4:porti1e4:porti2eeGARBAGE", "d4:porti1eeGARBAGE",
+		"d4:porti01ee", "d4:porti1e2:idi1ee", "d4:port", "d4:porti-0ee",
+		"d7:unknownd1:bi1e1:ai2eee", "d7:unknownli1e", "d7:unknown02:abe"} {
+		var args Args
+		var e *bencode.SyntaxError
+		err := args.UnmarshalBencode([]byte(str))
+		if !errors.As(err, &e) {
+			t.Fatalf("unexpected error of %q: %v", str, err)
+		}
+	}
+}
+
+func TestMsg(t *testing.T) {
+	str := "d1:ad2:id20:abcdefghij01234567896:target20:mnopqrstuvwxyz123456e" +
+		"1:eli201e5:Errore2:ip6:\x01\x02\x03\x04\x1a\xe11:q9:find_node" +
+		"3:seqi3e1:t2:aa1:v4:LT011:y1:qe"
+	var msg Msg
+	err := bencode.Decode([]byte(str), &msg)
+	if err != nil {
+		t.Fatalf("FATAL: decode: %v", err)
+	}
+	if msg.T != "aa" || msg.Y != "q" || msg.Q != "find_node" || string(msg.V) != "LT01" ||
+		msg.A == nil || string(msg.A.ID[:]) != "abcdefghij0123456789" ||
+		msg.A.Target == nil || string(msg.A.Target[:]) != "mnopqrstuvwxyz123456" ||
+		len(msg.E) != 2 || msg.E[1] != "Error" || string(msg.IP) != "6:\x01\x02\x03\x04\x1a\xe1" ||
+		msg.Seq != 3 || msg.R != nil {
+		t.Fatalf("unexpected decoded: %+v", msg)
+	}
+	data, err := bencode.Encode(msg)
+	if err != nil {
+		t.Fatalf("FATAL: encode: %v", err)
+	}
+	if string(data) != str {
+		t.Fatalf("unexpected encoded: %q", data)
+	}
+
+	// the generated methods follow the options of Decoder as reflection
+	lenient := []byte("d1:y1:q1:t03:abc1:eli020ei99999999999999999999ee" +
+		"1:ad4:porti01e2:id20:abcdefghij0123456789e1:v1:ve")
+	decode := func(v interface{}, set func(dec bencode.Decoder)) error {
+		dec := bencode.NewBytesDecoder(lenient)
+		set(dec)
+		return dec.Decode(v)
+	}
+	for _, set := range []func(dec bencode.Decoder){
+		func(dec bencode.Decoder) {},
+		func(dec bencode.Decoder) { dec.AllowNonCanonical() },
+		func(dec bencode.Decoder) { dec.UseBigInt() },
+	} {
+		var msg Msg
+		var plain plainMsg
+		err := decode(&msg, set)
+		want := decode(&plain, set)
+		if (err == nil) != (want == nil) {
+			t.Fatalf("unexpected error: %v, reflection error: %v", err, want)
+		}
+	}
+	set := func(dec bencode.Decoder) {
+		dec.AllowNonCanonical()
+		dec.UseBigInt()
+		dec.NoCopy()
+	}
+	msg = Msg{}
+	var plain plainMsg
+	if err = decode(&msg, set); err != nil {
+		t.Fatalf("FATAL: decode lenient: %v", err)
+	}
+	if err = decode(&plain, set); err != nil {
+		t.Fatalf("FATAL: decode lenient by reflection: %v", err)
+	}
+	data, _ = bencode.Encode(msg)
+	want, _ := bencode.Encode(plain)
+	if string(data) != string(want) {
+		t.Fatalf("unexpected lenient decoded: %q, want %q", data, want)
+	}
+	if &msg.V[0] != &lenient[len(lenient)-2] {
+		t.Fatalf("expected []byte aliasing input with NoCopy")
+	}
+}
+
+// plainMsg is the same as Msg without generated methods
+type plainMsg struct {
+	Header
+	Q   string             `bencode:"q,omitempty"`
+	A   *plainArgs         `bencode:"a,omitempty"`
+	E   []interface{}      `bencode:"e,omitempty"`
+	IP  bencode.RawMessage `bencode:"ip,omitempty"`
+	Seq int64              `bencode:",omitempty"`
+}
+
+var findNode = []byte("d1:ad2:id20:abcdefghij01234567896:target20:mnopqrstuvwxyz123456e" +
+	"1:q9:find_node1:t2:aa1:v4:LT011:y1:qe")
+
+func BenchmarkUnmarshalGenerated(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var msg Msg
+		err := msg.UnmarshalBencode(findNode)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalReflect(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var msg plainMsg
+		err := bencode.Decode(findNode, &msg)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
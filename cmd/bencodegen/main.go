@@ -0,0 +1,110 @@
+// Command bencodegen generates MarshalBencode and UnmarshalBencode
+// methods for struct types, so they are encoded and decoded without
+// reflection. The struct types are annotated by a comment line
+//
+//	//bencode:generate
+//
+// or named by -type flag, and the generated methods follow the same tag
+// rules as bencode.Encode and bencode.Decode. Typical usage is
+//
+//	//go:generate bencodegen
+//
+// in any file of the package, the types of the whole package are
+// generated into one file, which is <package>_bencode.go by default.
+//
+// Fields of string, []byte, byte array, integer and generated struct
+// types are handled directly, the others are handled by bencode package.
+// The generated UnmarshalBencode scans its input in place, and it is as
+// strict as bencode.Decode, trailing data after the dict is rejected. The
+// generated UnmarshalBencodeOptions is called by bencode.Decoder instead,
+// so the options such as AllowNonCanonical are kept.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	types := flag.String("type", "", "comma-separated list of type names, default is the annotated types")
+	output := flag.String("output", "", "output file name, default is <package>_bencode.go")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: bencodegen [flags] [directory]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+	var names []string
+	if len(*types) > 0 {
+		names = strings.Split(*types, ",")
+	}
+	err := run(dir, *output, names)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bencodegen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(dir, output string, names []string) error {
+	src, pkg, err := generate(dir, output, names)
+	if err != nil {
+		return err
+	}
+	if len(output) == 0 {
+		output = pkg + "_bencode.go"
+	}
+	if !filepath.IsAbs(output) {
+		output = filepath.Join(dir, output)
+	}
+	return ioutil.WriteFile(output, src, 0644)
+}
+
+// generate parses the package in dir and returns the generated source
+// and the package name, the file named output is skipped
+func generate(dir, output string, names []string) ([]byte, string, error) {
+	fset := token.NewFileSet()
+	filter := func(fi os.FileInfo) bool {
+		name := fi.Name()
+		if strings.HasSuffix(name, "_test.go") {
+			return false
+		}
+		if len(output) > 0 {
+			return name != filepath.Base(output)
+		}
+		return !strings.HasSuffix(name, "_bencode.go")
+	}
+	pkgs, err := parser.ParseDir(fset, dir, filter, parser.ParseComments)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(pkgs) != 1 {
+		return nil, "", fmt.Errorf("expected one package in %s, found %d", dir, len(pkgs))
+	}
+	var pkg *ast.Package
+	for _, p := range pkgs {
+		pkg = p
+	}
+	var files []*ast.File
+	for _, file := range pkg.Files {
+		files = append(files, file)
+	}
+	g := newGenerator(pkg.Name, files)
+	if len(names) > 0 {
+		err = g.setTypes(names)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	src, err := g.generate()
+	return src, pkg.Name, err
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	dir := filepath.Join("internal", "krpc")
+	src, pkg, err := generate(dir, "", nil)
+	if err != nil {
+		t.Fatalf("FATAL: generate: %v", err)
+	}
+	want, err := ioutil.ReadFile(filepath.Join(dir, pkg+"_bencode.go"))
+	if err != nil {
+		t.Fatalf("FATAL: read generated file: %v", err)
+	}
+	if string(src) != string(want) {
+		t.Fatalf("generated file is out of date, run go generate in %s", dir)
+	}
+
+	_, _, err = generate(dir, "", []string{"NodeID"})
+	if err == nil {
+		t.Fatalf("expected error of non-struct type")
+	}
+}
+
+// embedTest compares the generated methods with reflection
+const embedTest = `package embed
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lwch/bencode"
+)
+
+// plainOuter has the same fields as Outer without generated methods
+type plainOuter Outer
+
+type plainNode struct {
+	*plainNode
+	V int
+}
+
+func TestEmbedded(t *testing.T) {
+	outer := Outer{Inner: &Inner{A: 1, C: 2}, Other: Other{C: 3}, B: 4}
+	data, err := outer.MarshalBencode()
+	if err != nil {
+		t.Fatalf("FATAL: marshal: %v", err)
+	}
+	want, err := bencode.Encode(plainOuter(outer))
+	if err != nil {
+		t.Fatalf("FATAL: encode: %v", err)
+	}
+	if string(data) != string(want) || string(data) != "d1:ai4e1:ci2ee" {
+		t.Fatalf("unexpected marshaled: %q, want %q", data, want)
+	}
+	data, _ = Outer{B: 4}.MarshalBencode()
+	want, _ = bencode.Encode(plainOuter{B: 4})
+	if string(data) != string(want) {
+		t.Fatalf("unexpected marshaled of nil embedded: %q, want %q", data, want)
+	}
+
+	var dec Outer
+	var plain plainOuter
+	str := []byte("d1:ai5e1:ci6ee")
+	if err = dec.UnmarshalBencode(str); err != nil {
+		t.Fatalf("FATAL: unmarshal: %v", err)
+	}
+	if err = bencode.Decode(str, &plain); err != nil {
+		t.Fatalf("FATAL: decode: %v", err)
+	}
+	if !reflect.DeepEqual(dec, Outer(plain)) {
+		t.Fatalf("unexpected unmarshaled: %+v, want %+v", dec, plain)
+	}
+
+	node := Node{Node: &Node{V: 2}, V: 1}
+	data, err = node.MarshalBencode()
+	if err != nil {
+		t.Fatalf("FATAL: marshal node: %v", err)
+	}
+	want, _ = bencode.Encode(plainNode{plainNode: &plainNode{V: 2}, V: 1})
+	if string(data) != string(want) {
+		t.Fatalf("unexpected marshaled node: %q, want %q", data, want)
+	}
+	var n Node
+	if err = n.UnmarshalBencode(data); err != nil || n.V != 1 || n.Node != nil {
+		t.Fatalf("unexpected unmarshaled node: %+v, %v", n, err)
+	}
+
+	// nil pointer is not encoded unless omitempty
+	if _, err = (Holder{}).MarshalBencode(); err == nil {
+		t.Fatalf("expected error of nil pointer")
+	}
+}
+`
+
+func TestGenerateEmbedded(t *testing.T) {
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command is not found")
+	}
+	// the package is inside of the module, so it imports bencode package
+	dir, err := ioutil.TempDir(".", "_embed")
+	if err != nil {
+		t.Fatalf("FATAL: create dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	src := `package embed
+
+type Inner struct {
+	A int
+	C int ` + "`bencode:\"c\"`" + `
+}
+
+type Other struct {
+	C int
+}
+
+//bencode:generate
+type Outer struct {
+	*Inner
+	Other
+	B int ` + "`bencode:\"a\"`" + `
+}
+
+//bencode:generate
+type Node struct {
+	*Node
+	V int
+}
+
+//bencode:generate
+type Holder struct {
+	N *Node
+}
+`
+	err = ioutil.WriteFile(filepath.Join(dir, "embed.go"), []byte(src), 0644)
+	if err != nil {
+		t.Fatalf("FATAL: write source: %v", err)
+	}
+	out, pkg, err := generate(dir, "", nil)
+	if err != nil {
+		t.Fatalf("FATAL: generate: %v", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, pkg+"_bencode.go"), out, 0644)
+	if err != nil {
+		t.Fatalf("FATAL: write generated: %v", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "embed_test.go"), []byte(embedTest), 0644)
+	if err != nil {
+		t.Fatalf("FATAL: write test: %v", err)
+	}
+	cmd := exec.Command(gobin, "test", "./"+filepath.Base(dir))
+	result, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("FATAL: test generated: %v\n%s", err, result)
+	}
+}
@@ -2,7 +2,16 @@ package bencode
 
 import (
 	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 func TestDecodeNumber(t *testing.T) {
@@ -43,6 +52,16 @@ func TestDecodeString(t *testing.T) {
 	if !bytes.Equal(bs[:], []byte(s)) {
 		t.Fatalf("unexpected bytes value: %s", string(bs[:]))
 	}
+	for _, str := range []string{"2:xy", "4:wxyz"} {
+		var e *UnmarshalTypeError
+		err = Decode([]byte(str), &bs)
+		if !errors.As(err, &e) {
+			t.Fatalf("unexpected error of %s into [3]byte: %v", str, err)
+		}
+		if string(bs[:]) != s {
+			t.Fatalf("unexpected bytes value after %s: %s", str, string(bs[:]))
+		}
+	}
 	var intf interface{}
 	err = Decode(str, &intf)
 	if err != nil {
@@ -107,14 +126,16 @@ func TestDecodeListString(t *testing.T) {
 	}
 	var as [2]string
 	err = Decode(str, &as)
+	if _, ok := err.(*UnmarshalTypeError); !ok {
+		t.Fatalf("unexpected error of decode longer list to array: %v", err)
+	}
+	as4 := [4]string{3: "x"}
+	err = Decode(str, &as4)
 	if err != nil {
 		t.Fatalf("FATAL: decode string array: %v", err)
 	}
-	if as[0] != "a" {
-		t.Fatalf("unexpected slice value of 0: %s", ss[0])
-	}
-	if as[1] != "b" {
-		t.Fatalf("unexpected slice value of 1: %s", ss[1])
+	if as4 != [4]string{"a", "b", "c", ""} {
+		t.Fatalf("unexpected array value: %v", as4)
 	}
 
 	var sbs [][]byte
@@ -134,7 +155,7 @@ func TestDecodeListString(t *testing.T) {
 	if len(sbs[2]) != 1 || sbs[2][0] != 'c' {
 		t.Fatalf("unexpected slice bytes value of 2: %s", string(sbs[2]))
 	}
-	var sba [2][1]byte
+	var sba [3][1]byte
 	err = Decode(str, &sba)
 	if err != nil {
 		t.Fatalf("FATAL: decode bytes array: %v", err)
@@ -162,7 +183,7 @@ func TestDecodeListInt(t *testing.T) {
 			t.Fatalf("unexpected number slice value of %d: %d", i, si[i])
 		}
 	}
-	var ai [2]int
+	var ai [3]int
 	err = Decode(str, &ai)
 	if err != nil {
 		t.Fatalf("FATAL: decode int array: %v", err)
@@ -274,6 +295,48 @@ func TestDecodeInherit(t *testing.T) {
 	}
 }
 
+func TestDecodeEmbeddedConflict(t *testing.T) {
+	type inner struct {
+		A int
+		C int `bencode:"c"`
+	}
+	type outer struct {
+		*inner
+		C int `bencode:"a"`
+	}
+	var o outer
+	err := Decode([]byte("d1:ai2e1:ci3ee"), &o)
+	if err != nil {
+		t.Fatalf("FATAL: decode embedded: %v", err)
+	}
+	if o.C != 2 || o.inner != nil {
+		t.Fatalf("unexpected decoded embedded: %+v", o)
+	}
+
+	type tagged struct {
+		A int `bencode:"a"`
+	}
+	type untagged struct {
+		A int
+		B int
+	}
+	type other struct {
+		B int
+	}
+	var v struct {
+		untagged
+		tagged
+		other
+	}
+	err = Decode([]byte("d1:ai1e1:bi2ee"), &v)
+	if err != nil {
+		t.Fatalf("FATAL: decode ambiguous: %v", err)
+	}
+	if v.tagged.A != 1 || v.untagged.A != 0 || v.untagged.B != 0 || v.other.B != 0 {
+		t.Fatalf("unexpected decoded ambiguous: %+v", v)
+	}
+}
+
 func TestDecodeAnnounce(t *testing.T) {
 	data := []byte{
 		0x64, 0x31, 0x3a, 0x61, 0x64, 0x32, 0x3a, 0x69, 0x64, 0x32, 0x30, 0x3a, 0xf5, 0xe1, 0x44, 0x56,
@@ -326,3 +389,707 @@ func TestDecodeListStruct(t *testing.T) {
 		t.Fatalf("unexpected value of list[1]: %d %d", list[1].A, list[1].B)
 	}
 }
+
+func TestDecodeListList(t *testing.T) {
+	str := []byte("ll1:a1:bel1:cee")
+	var sss [][]string
+	err := Decode(str, &sss)
+	if err != nil {
+		t.Fatalf("FATAL: decode string slice slice: %v", err)
+	}
+	if len(sss) != 2 {
+		t.Fatalf("unexpected slice size: %d", len(sss))
+	}
+	if len(sss[0]) != 2 || sss[0][0] != "a" || sss[0][1] != "b" {
+		t.Fatalf("unexpected slice value of 0: %v", sss[0])
+	}
+	if len(sss[1]) != 1 || sss[1][0] != "c" {
+		t.Fatalf("unexpected slice value of 1: %v", sss[1])
+	}
+	var ass [2][2]string
+	err = Decode(str, &ass)
+	if err != nil {
+		t.Fatalf("FATAL: decode string array array: %v", err)
+	}
+	if ass[0][1] != "b" || ass[1][0] != "c" {
+		t.Fatalf("unexpected array value: %v", ass)
+	}
+	var intf interface{}
+	err = Decode([]byte("lli1eel1:aee"), &intf)
+	if err != nil {
+		t.Fatalf("FATAL: decode list list to interface: %v", err)
+	}
+	list := intf.([]interface{})
+	if len(list) != 2 {
+		t.Fatalf("unexpected interface slice size: %d", len(list))
+	}
+	if list[0].([]interface{})[0] != 1 {
+		t.Fatalf("unexpected interface value of 0: %v", list[0])
+	}
+	if list[1].([]interface{})[0] != "a" {
+		t.Fatalf("unexpected interface value of 1: %v", list[1])
+	}
+}
+
+func TestDecodeAnnounceList(t *testing.T) {
+	str := []byte("d8:announce3:abc13:announce-listll3:abcel3:def3:efgeee")
+	var torrent struct {
+		Announce     string     `bencode:"announce"`
+		AnnounceList [][]string `bencode:"announce-list"`
+	}
+	err := Decode(str, &torrent)
+	if err != nil {
+		t.Fatalf("FATAL: decode announce list: %v", err)
+	}
+	if len(torrent.AnnounceList) != 2 {
+		t.Fatalf("unexpected announce-list size: %d", len(torrent.AnnounceList))
+	}
+	if len(torrent.AnnounceList[1]) != 2 || torrent.AnnounceList[1][1] != "efg" {
+		t.Fatalf("unexpected announce-list value of 1: %v", torrent.AnnounceList[1])
+	}
+	var intf interface{}
+	err = Decode(str, &intf)
+	if err != nil {
+		t.Fatalf("FATAL: decode announce list to interface: %v", err)
+	}
+	m := intf.(map[string]interface{})
+	if m["announce"] != "abc" {
+		t.Fatalf("unexpected announce value: %v", m["announce"])
+	}
+	list := m["announce-list"].([]interface{})
+	if len(list) != 2 || list[0].([]interface{})[0] != "abc" {
+		t.Fatalf("unexpected announce-list value: %v", list)
+	}
+}
+
+type hexID string
+
+func (id *hexID) UnmarshalBencode(data []byte) error {
+	var str string
+	err := Decode(data, &str)
+	if err != nil {
+		return err
+	}
+	*id = hexID(fmt.Sprintf("%x", str))
+	return nil
+}
+
+func TestDecodeUnmarshaler(t *testing.T) {
+	var id hexID
+	err := Decode([]byte("2:ab"), &id)
+	if err != nil {
+		t.Fatalf("FATAL: decode unmarshaler: %v", err)
+	}
+	if id != "6162" {
+		t.Fatalf("unexpected unmarshaler value: %s", id)
+	}
+	var obj struct {
+		ID    hexID            `bencode:"id"`
+		Ptr   *hexID           `bencode:"ptr"`
+		Nodes []hexID          `bencode:"nodes"`
+		Peers map[string]hexID `bencode:"peers"`
+	}
+	str := []byte("d2:id1:a5:nodesl1:b1:ce5:peersd1:x1:de3:ptr1:ee")
+	err = Decode(str, &obj)
+	if err != nil {
+		t.Fatalf("FATAL: decode unmarshaler struct: %v", err)
+	}
+	if obj.ID != "61" {
+		t.Fatalf("unexpected value of id: %s", obj.ID)
+	}
+	if obj.Ptr == nil || *obj.Ptr != "65" {
+		t.Fatalf("unexpected value of ptr: %v", obj.Ptr)
+	}
+	if len(obj.Nodes) != 2 || obj.Nodes[0] != "62" || obj.Nodes[1] != "63" {
+		t.Fatalf("unexpected value of nodes: %v", obj.Nodes)
+	}
+	if obj.Peers["x"] != "64" {
+		t.Fatalf("unexpected value of peers: %v", obj.Peers)
+	}
+}
+
+func TestDecodeTagOptions(t *testing.T) {
+	str := []byte("d5:cache3:abc7:comment3:def5:tokeni1ee")
+	var obj struct {
+		Cache   string `bencode:"-"`
+		Comment string `bencode:"comment,omitempty"`
+		Token   int    `bencode:",omitempty"`
+	}
+	err := Decode(str, &obj)
+	if err != nil {
+		t.Fatalf("FATAL: decode tag options: %v", err)
+	}
+	if obj.Cache != "" {
+		t.Fatalf("unexpected value of cache: %s", obj.Cache)
+	}
+	if obj.Comment != "def" {
+		t.Fatalf("unexpected value of comment: %s", obj.Comment)
+	}
+	if obj.Token != 1 {
+		t.Fatalf("unexpected value of token: %d", obj.Token)
+	}
+}
+
+func TestDecodeVisibility(t *testing.T) {
+	type Hdr struct {
+		Transaction string `bencode:"t"`
+	}
+	type query struct {
+		Action string `bencode:"q"`
+	}
+	type args struct {
+		ID string `bencode:"id"`
+	}
+	var req struct {
+		query
+		*Hdr
+		*args
+		Type    string `bencode:"y"`
+		private string
+	}
+	str := []byte("d2:id3:abc7:private3:def1:q4:ping1:t2:aa1:y1:qe")
+	err := Decode(str, &req)
+	if err != nil {
+		t.Fatalf("FATAL: decode visibility: %v", err)
+	}
+	if req.Action != "ping" {
+		t.Fatalf("unexpected value of q: %s", req.Action)
+	}
+	if req.Hdr == nil || req.Transaction != "aa" {
+		t.Fatalf("unexpected value of t: %v", req.Hdr)
+	}
+	if req.args != nil {
+		t.Fatalf("unexpected value of unexported embedded pointer: %v", req.args)
+	}
+	if req.Type != "q" {
+		t.Fatalf("unexpected value of y: %s", req.Type)
+	}
+	if req.private != "" {
+		t.Fatalf("unexpected value of private: %s", req.private)
+	}
+}
+
+func TestDecodePointer(t *testing.T) {
+	type peer struct {
+		IP   string `bencode:"ip"`
+		Port int    `bencode:"port"`
+	}
+	var resp struct {
+		Interval    *int       `bencode:"interval"`
+		MinInterval *int       `bencode:"min interval"`
+		Complete    **uint32   `bencode:"complete"`
+		Reason      *string    `bencode:"failure reason"`
+		Pieces      *[]byte    `bencode:"pieces"`
+		Peer        *peer      `bencode:"peer"`
+		Peers       []*peer    `bencode:"peers"`
+		URLs        *[]*string `bencode:"urls"`
+	}
+	str := []byte("d8:completei0e8:intervali1800e4:peerd2:ip3:abc4:porti1ee5:peersld2:ip3:def4:porti2eee6:pieces3:xyz4:urlsl1:a1:bee")
+	err := Decode(str, &resp)
+	if err != nil {
+		t.Fatalf("FATAL: decode pointer: %v", err)
+	}
+	if resp.Interval == nil || *resp.Interval != 1800 {
+		t.Fatalf("unexpected value of interval: %v", resp.Interval)
+	}
+	if resp.MinInterval != nil {
+		t.Fatalf("unexpected value of min interval: %v", resp.MinInterval)
+	}
+	if resp.Complete == nil || *resp.Complete == nil || **resp.Complete != 0 {
+		t.Fatalf("unexpected value of complete: %v", resp.Complete)
+	}
+	if resp.Reason != nil {
+		t.Fatalf("unexpected value of failure reason: %v", resp.Reason)
+	}
+	if resp.Pieces == nil || string(*resp.Pieces) != "xyz" {
+		t.Fatalf("unexpected value of pieces: %v", resp.Pieces)
+	}
+	if resp.Peer == nil || resp.Peer.IP != "abc" || resp.Peer.Port != 1 {
+		t.Fatalf("unexpected value of peer: %v", resp.Peer)
+	}
+	if len(resp.Peers) != 1 || resp.Peers[0].IP != "def" || resp.Peers[0].Port != 2 {
+		t.Fatalf("unexpected value of peers: %v", resp.Peers)
+	}
+	if resp.URLs == nil || len(*resp.URLs) != 2 || *(*resp.URLs)[1] != "b" {
+		t.Fatalf("unexpected value of urls: %v", resp.URLs)
+	}
+	var n *int
+	err = Decode([]byte("i-1e"), &n)
+	if err != nil {
+		t.Fatalf("FATAL: decode pointer number: %v", err)
+	}
+	if n == nil || *n != -1 {
+		t.Fatalf("unexpected pointer number value: %v", n)
+	}
+}
+
+func TestDecodeBigInt(t *testing.T) {
+	str := []byte("d10:downloadedi123456789012345678901234567890e8:uploadedi-123456789012345678901234567890ee")
+	var stat struct {
+		Downloaded *big.Int `bencode:"downloaded"`
+		Uploaded   big.Int  `bencode:"uploaded"`
+	}
+	err := Decode(str, &stat)
+	if err != nil {
+		t.Fatalf("FATAL: decode big int: %v", err)
+	}
+	if stat.Downloaded == nil || stat.Downloaded.String() != "123456789012345678901234567890" {
+		t.Fatalf("unexpected value of downloaded: %v", stat.Downloaded)
+	}
+	if stat.Uploaded.String() != "-123456789012345678901234567890" {
+		t.Fatalf("unexpected value of uploaded: %s", stat.Uploaded.String())
+	}
+	var n big.Int
+	err = Decode([]byte("i1e"), &n)
+	if err != nil {
+		t.Fatalf("FATAL: decode small big int: %v", err)
+	}
+	if n.Int64() != 1 {
+		t.Fatalf("unexpected small big int value: %s", n.String())
+	}
+
+	var i int64
+	err = Decode([]byte("i9223372036854775808e"), &i)
+	if err == nil {
+		t.Fatal("expected error on int64 overflow")
+	}
+	var u uint64
+	err = Decode([]byte("i18446744073709551615e"), &u)
+	if err != nil {
+		t.Fatalf("FATAL: decode max uint64: %v", err)
+	}
+	if u != 18446744073709551615 {
+		t.Fatalf("unexpected max uint64 value: %d", u)
+	}
+	var u8 uint8
+	err = Decode([]byte("i256e"), &u8)
+	if err == nil {
+		t.Fatal("expected error on uint8 overflow")
+	}
+	for _, str := range []string{"i-1e", "i-9223372036854775808e", "i-18446744073709551616e"} {
+		var u uint64
+		var e *UnmarshalTypeError
+		err = Decode([]byte(str), &u)
+		if !errors.As(err, &e) {
+			t.Fatalf("unexpected error of negative %s: %v", str, err)
+		}
+	}
+
+	var intf interface{}
+	err = Decode(str, &intf)
+	if err == nil {
+		t.Fatal("expected error on decode big int to interface without UseBigInt")
+	}
+	dec := NewDecoder(bytes.NewReader(str))
+	dec.UseBigInt()
+	err = dec.Decode(&intf)
+	if err != nil {
+		t.Fatalf("FATAL: decode big int to interface: %v", err)
+	}
+	m := intf.(map[string]interface{})
+	if m["downloaded"].(*big.Int).String() != "123456789012345678901234567890" {
+		t.Fatalf("unexpected value of downloaded: %v", m["downloaded"])
+	}
+	dec = NewDecoder(bytes.NewReader([]byte("li1ei123456789012345678901234567890ee")))
+	dec.UseBigInt()
+	err = dec.Decode(&intf)
+	if err != nil {
+		t.Fatalf("FATAL: decode big int list to interface: %v", err)
+	}
+	list := intf.([]interface{})
+	if list[0] != 1 {
+		t.Fatalf("unexpected list value of 0: %v", list[0])
+	}
+	if list[1].(*big.Int).String() != "123456789012345678901234567890" {
+		t.Fatalf("unexpected list value of 1: %v", list[1])
+	}
+}
+
+func TestDecodeStrict(t *testing.T) {
+	invalid := []string{
+		"ie", "i-e", "i-0e", "i03e", "i-03e", "i+1e", "i1.5e",
+		"03:abc", "d1:bi1e1:ai2ee", "d1:ai1e1:ai2ee",
+	}
+	for _, str := range invalid {
+		var intf interface{}
+		err := Decode([]byte(str), &intf)
+		if err == nil {
+			t.Fatalf("expected error on decode %s", str)
+		}
+	}
+	valid := []string{"i0e", "i-1e", "i10e", "0:", "10:abcdefghij", "d1:ai1e1:bi2ee"}
+	for _, str := range valid {
+		var intf interface{}
+		err := Decode([]byte(str), &intf)
+		if err != nil {
+			t.Fatalf("FATAL: decode %s: %v", str, err)
+		}
+	}
+
+	lenient := map[string]interface{}{
+		"i03e":           3,
+		"i-0e":           0,
+		"03:abc":         "abc",
+		"d1:bi1e1:ai2ee": map[string]interface{}{"a": 2, "b": 1},
+	}
+	for str, want := range lenient {
+		dec := NewDecoder(bytes.NewReader([]byte(str)))
+		dec.AllowNonCanonical()
+		var intf interface{}
+		err := dec.Decode(&intf)
+		if err != nil {
+			t.Fatalf("FATAL: decode non-canonical %s: %v", str, err)
+		}
+		if fmt.Sprint(intf) != fmt.Sprint(want) {
+			t.Fatalf("unexpected non-canonical value of %s: %v", str, intf)
+		}
+	}
+	dec := NewDecoder(bytes.NewReader([]byte("ie")))
+	dec.AllowNonCanonical()
+	var n int
+	err := dec.Decode(&n)
+	if err == nil {
+		t.Fatal("expected error on decode empty number")
+	}
+}
+
+func TestDecodeStream(t *testing.T) {
+	str := "d1:ai1ee4:spamli1ei2eetail"
+	dec := NewDecoder(iotest.OneByteReader(bytes.NewReader([]byte(str))))
+	var m map[string]interface{}
+	err := dec.Decode(&m)
+	if err != nil {
+		t.Fatalf("FATAL: decode first value: %v", err)
+	}
+	if m["a"] != 1 {
+		t.Fatalf("unexpected first value: %v", m)
+	}
+	if dec.InputOffset() != 8 {
+		t.Fatalf("unexpected offset after first value: %d", dec.InputOffset())
+	}
+	var s string
+	err = dec.Decode(&s)
+	if err != nil {
+		t.Fatalf("FATAL: decode second value: %v", err)
+	}
+	if s != "spam" {
+		t.Fatalf("unexpected second value: %s", s)
+	}
+	var list []int
+	err = dec.Decode(&list)
+	if err != nil {
+		t.Fatalf("FATAL: decode third value: %v", err)
+	}
+	if len(list) != 2 || list[1] != 2 {
+		t.Fatalf("unexpected third value: %v", list)
+	}
+	if dec.InputOffset() != int64(len(str)-len("tail")) {
+		t.Fatalf("unexpected offset after third value: %d", dec.InputOffset())
+	}
+
+	// the reader is buffered unless it is io.ByteScanner
+	r := bytes.NewReader([]byte("i1ei2etail"))
+	dec = NewDecoder(struct{ io.Reader }{r})
+	var n int
+	for i := 1; i <= 2; i++ {
+		err = dec.Decode(&n)
+		if err != nil {
+			t.Fatalf("FATAL: decode number %d: %v", i, err)
+		}
+		if n != i {
+			t.Fatalf("unexpected number value: %d", n)
+		}
+	}
+	rest, err := ioutil.ReadAll(dec.Buffered())
+	if err != nil {
+		t.Fatalf("FATAL: read buffered: %v", err)
+	}
+	if string(rest) != "tail" {
+		t.Fatalf("unexpected buffered data: %s", string(rest))
+	}
+	r.Seek(0, io.SeekStart)
+	dec = NewDecoder(r)
+	for i := 1; i <= 2; i++ {
+		err = dec.Decode(&n)
+		if err != nil {
+			t.Fatalf("FATAL: decode number %d: %v", i, err)
+		}
+	}
+	rest, _ = ioutil.ReadAll(r)
+	if string(rest) != "tail" {
+		t.Fatalf("unexpected unread data: %s", string(rest))
+	}
+
+	dec = NewDecoder(bytes.NewReader([]byte("i1e")))
+	err = dec.Decode(&n)
+	if err != nil {
+		t.Fatalf("FATAL: decode last value: %v", err)
+	}
+	err = dec.Decode(&n)
+	if err != io.EOF {
+		t.Fatalf("unexpected error at the end of stream: %v", err)
+	}
+}
+
+func TestDecodeBytesNoCopy(t *testing.T) {
+	type info struct {
+		Name   string     `bencode:"name"`
+		Pieces []byte     `bencode:"pieces"`
+		Raw    RawMessage `bencode:"raw"`
+		Hash   [4]byte    `bencode:"hash"`
+		Skip   int        `bencode:"-"`
+	}
+	data := []byte("d4:hash4:abcd4:name3:abc6:pieces6:\x01\x02\x03\x04\x05\x063:rawli1ee4:skip3:xyze")
+	var v info
+	err := DecodeBytesNoCopy(data, &v)
+	if err != nil {
+		t.Fatalf("FATAL: decode: %v", err)
+	}
+	if v.Name != "abc" || string(v.Pieces) != "\x01\x02\x03\x04\x05\x06" ||
+		string(v.Raw) != "li1ee" || string(v.Hash[:]) != "abcd" {
+		t.Fatalf("unexpected value: %+v", v)
+	}
+	if &v.Pieces[0] != &data[bytes.Index(data, []byte("\x01"))] {
+		t.Fatalf("pieces is not aliased")
+	}
+	if cap(v.Pieces) != len(v.Pieces) {
+		t.Fatalf("unexpected capacity of pieces: %d", cap(v.Pieces))
+	}
+	v.Pieces = append(v.Pieces, 'x')
+	if !bytes.Contains(data, []byte("\x063:raw")) {
+		t.Fatalf("append overwrites the input")
+	}
+
+	for _, str := range []string{"d6:pieces7:abce", "d6:pieces", "d1:b0:1:a0:e", "d4:skip9:xe"} {
+		var v info
+		err := DecodeBytesNoCopy([]byte(str), &v)
+		if _, ok := err.(*SyntaxError); !ok {
+			t.Fatalf("unexpected error of %q: %v", str, err)
+		}
+	}
+	if DecodeBytesNoCopy(data, v) == nil {
+		t.Fatalf("expected error of non-pointer")
+	}
+
+	// NoCopy is combined with the other options of Decoder
+	data = []byte("d4:sizei123456789012345678901234567890e4:data3:abce1:x")
+	dec := NewBytesDecoder(data)
+	dec.NoCopy()
+	dec.UseBigInt()
+	dec.AllowNonCanonical()
+	var m map[string]interface{}
+	err = dec.Decode(&m)
+	if err != nil {
+		t.Fatalf("FATAL: decode with options: %v", err)
+	}
+	if fmt.Sprint(m["size"]) != "123456789012345678901234567890" || m["data"] != "abc" {
+		t.Fatalf("unexpected value: %v", m)
+	}
+	var x RawMessage
+	err = dec.Decode(&x)
+	if err != nil || string(x) != "1:x" || dec.InputOffset() != int64(len(data)) {
+		t.Fatalf("unexpected next value: %v %q", err, x)
+	}
+	if dec.More() || dec.Decode(&x) != io.EOF {
+		t.Fatalf("expected EOF after values")
+	}
+	dec = NewBytesDecoder([]byte("d6:pieces6:abcdefe"))
+	dec.NoCopy()
+	dec.SetMaxStringLength(4)
+	if _, ok := dec.Decode(&v).(*LimitError); !ok {
+		t.Fatalf("expected limit error of string length")
+	}
+}
+
+func TestDecodeListTyped(t *testing.T) {
+	type port uint16
+	type name string
+	type node struct {
+		ID [4]byte `bencode:"id"`
+	}
+	type info struct {
+		Ports  []port        `bencode:"ports"`
+		Names  []name        `bencode:"names"`
+		Nodes  []*node       `bencode:"nodes"`
+		Hashes [][4]byte     `bencode:"hashes"`
+		Raws   []RawMessage  `bencode:"raws"`
+		Mixed  []interface{} `bencode:"mixed"`
+		Empty  []int         `bencode:"empty"`
+	}
+	str := "d5:emptyle6:hashesl4:abcd4:efghe5:mixedli1e1:ad1:ai1eeli2eee5:namesl1:a1:be" +
+		"5:nodesld2:id4:abcdee5:portsli6881ei80ee4:rawsli1e1:aee"
+	var v info
+	err := Decode([]byte(str), &v)
+	if err != nil {
+		t.Fatalf("FATAL: decode: %v", err)
+	}
+	if fmt.Sprint(v.Ports) != "[6881 80]" || fmt.Sprint(v.Names) != "[a b]" ||
+		len(v.Nodes) != 1 || string(v.Nodes[0].ID[:]) != "abcd" ||
+		len(v.Hashes) != 2 || string(v.Hashes[1][:]) != "efgh" ||
+		len(v.Raws) != 2 || string(v.Raws[0]) != "i1e" || string(v.Raws[1]) != "1:a" ||
+		v.Empty == nil || len(v.Empty) != 0 {
+		t.Fatalf("unexpected value: %+v", v)
+	}
+	if fmt.Sprintf("%#v", v.Mixed) != `[]interface {}{1, "a", map[string]interface {}{"a":1}, []interface {}{2}}` {
+		t.Fatalf("unexpected mixed list: %#v", v.Mixed)
+	}
+	var flags []bool
+	err = Decode([]byte("li1ei0ee"), &flags)
+	if err != nil {
+		t.Fatalf("FATAL: decode bool list: %v", err)
+	}
+	if fmt.Sprint(flags) != "[true false]" {
+		t.Fatalf("unexpected bool list: %v", flags)
+	}
+
+	run := func(str string, v interface{}, path string) {
+		err := Decode([]byte(str), v)
+		e, ok := err.(*UnmarshalTypeError)
+		if !ok {
+			t.Fatalf("unexpected error of %s: %v", str, err)
+		}
+		if e.Path != path {
+			t.Fatalf("unexpected path of %s: %s", str, e.Path)
+		}
+	}
+	run("d5:portsli1ei65536eee", &info{}, "ports[1]")
+	run("d5:portsl1:aee", &info{}, "ports[0]")
+	run("d5:nodesli1eee", &info{}, "nodes[0]")
+	run("li1ei2ee", &[]bool{}, "[1]")
+	run("ld2:idi1eee", &[]node{}, "[0].id")
+	run("li1ei2ei3ee", &[2]int{}, "[2]")
+	run("li1ee", &struct{}{}, "")
+}
+
+// hexKey is a map key decoded from hex text
+type hexKey [2]byte
+
+func (k hexKey) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(k[:])), nil
+}
+
+func (k *hexKey) UnmarshalText(data []byte) error {
+	if len(data) != 4 {
+		return fmt.Errorf("invalid hex key: %s", data)
+	}
+	_, err := hex.Decode(k[:], data)
+	return err
+}
+
+// revHash is a hash stored in reversed byte order
+type revHash [3]byte
+
+func (h revHash) MarshalBinary() ([]byte, error) {
+	return []byte{h[2], h[1], h[0]}, nil
+}
+
+func (h *revHash) UnmarshalBinary(data []byte) error {
+	if len(data) != len(h) {
+		return fmt.Errorf("invalid hash length: %d", len(data))
+	}
+	*h = revHash{data[2], data[1], data[0]}
+	return nil
+}
+
+func TestDecodeMapTyped(t *testing.T) {
+	type name string
+	type file struct {
+		Length int64 `bencode:"length"`
+	}
+	var v struct {
+		Ints   map[string]int64                  `bencode:"ints"`
+		Bytes  map[string][]byte                 `bencode:"bytes"`
+		Files  map[string]file                   `bencode:"files"`
+		Names  map[name]*file                    `bencode:"names"`
+		Lists  map[string][]int                  `bencode:"lists"`
+		Hex    map[hexKey]string                 `bencode:"hex"`
+		Nested map[string]map[string]interface{} `bencode:"nested"`
+	}
+	str := "d5:bytesd1:a2:xye5:filesd1:ad6:lengthi1eee3:hexd4:00ff1:ae" +
+		"4:intsd1:ai9223372036854775807ee5:listsd1:ali1ei2eee" +
+		"5:namesd1:bd6:lengthi2eee6:nestedd1:ad1:bli1eeeee"
+	err := Decode([]byte(str), &v)
+	if err != nil {
+		t.Fatalf("FATAL: decode: %v", err)
+	}
+	if v.Ints["a"] != 9223372036854775807 || string(v.Bytes["a"]) != "xy" ||
+		v.Files["a"].Length != 1 || v.Names["b"] == nil || v.Names["b"].Length != 2 ||
+		fmt.Sprint(v.Lists["a"]) != "[1 2]" || v.Hex[hexKey{0, 0xff}] != "a" ||
+		fmt.Sprint(v.Nested["a"]["b"]) != "[1]" {
+		t.Fatalf("unexpected value: %+v", v)
+	}
+
+	for _, str := range []string{"d1:a1:be", "d1:ali1eee"} {
+		var v map[string]int
+		_, ok := Decode([]byte(str), &v).(*UnmarshalTypeError)
+		if !ok {
+			t.Fatalf("expected type error of %s", str)
+		}
+	}
+	var m map[int]string
+	if _, ok := Decode([]byte("d1:a1:be"), &m).(*UnmarshalTypeError); !ok {
+		t.Fatalf("expected type error of int key")
+	}
+	var h map[hexKey]string
+	if Decode([]byte("d1:a1:be"), &h) == nil {
+		t.Fatalf("expected error of invalid text key")
+	}
+}
+
+func TestDecodeTextUnmarshaler(t *testing.T) {
+	var v struct {
+		IP    net.IP          `bencode:"ip"`
+		Time  *time.Time      `bencode:"time"`
+		Hash  revHash         `bencode:"hash"`
+		Big   *big.Int        `bencode:"big"`
+		Peers map[revHash]int `bencode:"peers"`
+	}
+	err := Decode([]byte("d3:bigi42e4:hash3:cba2:ip7:1.2.3.4"+
+		"5:peersd3:zyxi1ee4:time20:2020-01-02T03:04:05Ze"), &v)
+	if err != nil {
+		t.Fatalf("FATAL: decode unmarshaler: %v", err)
+	}
+	if !v.IP.Equal(net.IPv4(1, 2, 3, 4)) {
+		t.Fatalf("unexpected ip: %v", v.IP)
+	}
+	if v.Time == nil || !v.Time.Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Fatalf("unexpected time: %v", v.Time)
+	}
+	if v.Hash != (revHash{'a', 'b', 'c'}) {
+		t.Fatalf("unexpected hash: %q", v.Hash[:])
+	}
+	if v.Big == nil || v.Big.Int64() != 42 {
+		t.Fatalf("unexpected big: %v", v.Big)
+	}
+	if len(v.Peers) != 1 || v.Peers[revHash{'x', 'y', 'z'}] != 1 {
+		t.Fatalf("unexpected peers: %v", v.Peers)
+	}
+
+	err = Decode([]byte("d2:ipi1ee"), &v)
+	if e, ok := err.(*UnmarshalTypeError); !ok || e.Value != "number" {
+		t.Fatalf("unexpected error of number ip: %v", err)
+	}
+	err = Decode([]byte("d4:hash2:abe"), &v)
+	if err == nil {
+		t.Fatalf("unexpected success of short hash")
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	var ping struct {
+		T string `bencode:"t"`
+		Y string `bencode:"y"`
+		Q string `bencode:"q"`
+		A struct {
+			ID [20]byte `bencode:"id"`
+		} `bencode:"a"`
+	}
+	data := []byte("d1:ad2:id20:abcdefghij0123456789e1:q4:ping1:t2:aa1:y1:qe")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		err := Decode(data, &ping)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -1,415 +1,903 @@
 package bencode
 
 import (
+	"bufio"
 	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
 	"reflect"
 	"strconv"
+	"strings"
 )
 
-// Decoder bencode decoder
+// Decoder bencode decoder, it reads the input through a buffer unless
+// the input implements io.ByteScanner, so successive values can be
+// decoded from the same stream
 type Decoder struct {
-	r io.Reader
+	*decoder
 }
 
-// NewDecoder create decoder from io.Reader
+// decoder is the state shared by the copies of Decoder
+type decoder struct {
+	r         byteReader    // nil when decoding data
+	buf       *bufio.Reader // buffer of the input created by NewDecoder
+	data      []byte        // input of NewBytesDecoder
+	noCopy    bool
+	offset    int64
+	tokens    []tokenState
+	useBigInt bool
+	lenient   bool
+	limits
+	d decodeState // reused by each call
+}
+
+// byteReader is the input which is read without buffering
+type byteReader interface {
+	io.Reader
+	io.ByteScanner
+}
+
+// limits of untrusted input, zero means unlimited
+type limits struct {
+	maxDepth    int
+	maxString   int64
+	maxBytes    int64
+	maxElements int64
+}
+
+// Unmarshaler is the interface implemented by types that can unmarshal
+// a bencode description of themselves
+type Unmarshaler interface {
+	UnmarshalBencode([]byte) error
+}
+
+var unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+
+// optionsUnmarshaler is implemented by the types generated by bencodegen,
+// so they are decoded with the options of Decoder
+type optionsUnmarshaler interface {
+	UnmarshalBencodeOptions(data []byte, opts DecodeOptions) error
+}
+
+// DecodeOptions are the options of Decoder passed to the
+// UnmarshalBencodeOptions methods generated by bencodegen
+type DecodeOptions struct {
+	AllowNonCanonical bool
+	UseBigInt         bool
+	NoCopy            bool
+}
+
+// NewDecoder create decoder from data with the options
+func (opts DecodeOptions) NewDecoder(data []byte) Decoder {
+	dec := NewBytesDecoder(data)
+	dec.lenient = opts.AllowNonCanonical
+	dec.useBigInt = opts.UseBigInt
+	dec.noCopy = opts.NoCopy
+	return dec
+}
+
+// NewDecoder create decoder from io.Reader, the input is not buffered
+// when it implements io.ByteScanner, such as *bytes.Reader and
+// *bufio.Reader, so it is read exactly to the end of each value
 func NewDecoder(r io.Reader) Decoder {
-	return Decoder{r: r}
+	if br, ok := r.(byteReader); ok {
+		return Decoder{&decoder{r: br}}
+	}
+	buf := bufio.NewReader(r)
+	return Decoder{&decoder{r: buf, buf: buf}}
+}
+
+// NewBytesDecoder create decoder from data, successive values are decoded
+// from data without buffering and reading
+func NewBytesDecoder(data []byte) Decoder {
+	return Decoder{&decoder{data: data}}
 }
 
-// Decode decode data
+// endError returns the syntax error of data after the value when the
+// input of size bytes is not consumed to the end
+func (dec Decoder) endError(size int) error {
+	if dec.offset == int64(size) {
+		return nil
+	}
+	d := decodeState{offset: dec.offset}
+	return d.syntaxError("unexpected data after value")
+}
+
+// Buffered returns a reader of the data remaining in the Decoder's buffer,
+// it is empty when the input is not buffered, or the rest of data for the
+// Decoder created by NewBytesDecoder
+func (dec Decoder) Buffered() io.Reader {
+	if dec.r == nil {
+		return bytes.NewReader(dec.data[dec.offset:])
+	}
+	if dec.buf == nil {
+		return bytes.NewReader(nil)
+	}
+	data, _ := dec.buf.Peek(dec.buf.Buffered())
+	return bytes.NewReader(data)
+}
+
+// InputOffset returns the count of bytes consumed by the Decoder
+func (dec Decoder) InputOffset() int64 {
+	return dec.offset
+}
+
+// UseBigInt causes the Decoder to decode a number which overflows int64
+// into an interface{} as *big.Int instead of returns an error
+func (dec Decoder) UseBigInt() {
+	dec.useBigInt = true
+}
+
+// AllowNonCanonical causes the Decoder to accept encodings which are not
+// canonical in BEP 3, such as leading zeros in numbers and string sizes
+// or unsorted dict keys, by default they are rejected
+func (dec Decoder) AllowNonCanonical() {
+	dec.lenient = true
+}
+
+// NoCopy causes the Decoder created by NewBytesDecoder to alias the []byte
+// values and the data passed to Unmarshaler into its input instead of
+// copying, so the input must not be modified while they are in use, it
+// has no effect on the Decoder reading from io.Reader
+func (dec Decoder) NoCopy() {
+	dec.noCopy = true
+}
+
+// SetMaxDepth limits the nesting depth of lists and dicts, the depth is
+// never more than 10000
+func (dec Decoder) SetMaxDepth(n int) {
+	dec.maxDepth = n
+}
+
+// SetMaxStringLength limits the length of each string
+func (dec Decoder) SetMaxStringLength(n int64) {
+	dec.maxString = n
+}
+
+// SetMaxTotalBytes limits the bytes read by each Decode call
+func (dec Decoder) SetMaxTotalBytes(n int64) {
+	dec.maxBytes = n
+}
+
+// SetMaxElements limits the total count of list elements and dict entries
+// decoded by each Decode call
+func (dec Decoder) SetMaxElements(n int64) {
+	dec.maxElements = n
+}
+
+// Decode decode the next value from its input, it returns io.EOF
+// when there is no more value
 func (dec Decoder) Decode(data interface{}) error {
 	if reflect.ValueOf(data).Kind() != reflect.Ptr {
 		return errors.New("input value is not pointer")
 	}
-	return decode(dec.r, "", reflect.ValueOf(data).Elem())
+	d := dec.state()
+	err := decode(d, reflect.ValueOf(data).Elem())
+	dec.offset = d.offset
+	if err != nil {
+		return err
+	}
+	dec.valueDone()
+	return nil
+}
+
+func (dec Decoder) state() *decodeState {
+	dec.d = decodeState{
+		r:         dec.r,
+		data:      dec.data,
+		noCopy:    dec.noCopy,
+		useBigInt: dec.useBigInt,
+		strict:    !dec.lenient,
+		offset:    dec.offset,
+		start:     dec.offset,
+		path:      dec.d.path[:0],
+		limits:    dec.limits,
+	}
+	return &dec.d
 }
 
 // Decode decode data in raw
 func Decode(data []byte, value interface{}) error {
-	return NewDecoder(bytes.NewReader(data)).Decode(value)
+	return NewBytesDecoder(data).Decode(value)
+}
+
+// DecodeBytesNoCopy decode data in raw like Decode, but the []byte values
+// and the data passed to Unmarshaler alias data instead of copying it,
+// so data must not be modified while they are in use, it is the same as
+// a Decoder created by NewBytesDecoder with NoCopy, which can be combined
+// with the other options
+func DecodeBytesNoCopy(data []byte, value interface{}) error {
+	dec := NewBytesDecoder(data)
+	dec.NoCopy()
+	return dec.Decode(value)
+}
+
+type decodeState struct {
+	r         byteReader // nil when decoding data
+	data      []byte
+	noCopy    bool          // strings alias data instead of copying
+	raw       *bytes.Buffer // copy of the bytes read from r
+	useBigInt bool
+	strict    bool
+	offset    int64
+	start     int64 // offset of the value
+	path      []pathStep
+	limits
+	depth    int
+	elements int64
+}
+
+// readByte reads the next byte of input
+func (d *decodeState) readByte() (byte, error) {
+	if d.maxBytes > 0 && d.offset-d.start >= d.maxBytes {
+		return 0, d.limitError("total bytes", d.maxBytes)
+	}
+	var ch byte
+	if d.r == nil {
+		if d.offset >= int64(len(d.data)) {
+			return 0, io.EOF
+		}
+		ch = d.data[d.offset]
+	} else {
+		var err error
+		ch, err = d.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if d.raw != nil {
+			d.raw.WriteByte(ch)
+		}
+	}
+	d.offset++
+	return ch, nil
+}
+
+// Read reads the input of r
+func (d *decodeState) Read(p []byte) (int, error) {
+	if d.maxBytes > 0 {
+		if d.offset-d.start >= d.maxBytes {
+			return 0, d.limitError("total bytes", d.maxBytes)
+		}
+		if remain := d.maxBytes - (d.offset - d.start); int64(len(p)) > remain {
+			p = p[:remain]
+		}
+	}
+	n, err := d.r.Read(p)
+	if d.raw != nil {
+		d.raw.Write(p[:n])
+	}
+	d.offset += int64(n)
+	return n, err
+}
+
+// next returns the next n bytes of data without copying, its capacity
+// is limited so appending to it never overwrites data
+func (d *decodeState) next(n uint64) ([]byte, error) {
+	if uint64(int64(len(d.data))-d.offset) < n {
+		d.offset = int64(len(d.data))
+		return nil, io.ErrUnexpectedEOF
+	}
+	start := d.offset
+	d.offset += int64(n)
+	return d.data[start:d.offset:d.offset], nil
+}
+
+func (d *decodeState) limitError(limit string, max int64) error {
+	return &LimitError{Limit: limit, Max: max, Offset: d.offset}
+}
+
+// maxNestingDepth limits the nesting depth when SetMaxDepth is not
+// called, so deeply nested input can not overflow the stack
+const maxNestingDepth = 10000
+
+// enter is called when start decoding a list or dict
+func (d *decodeState) enter() error {
+	d.depth++
+	max := d.maxDepth
+	if max <= 0 || max > maxNestingDepth {
+		max = maxNestingDepth
+	}
+	if d.depth > max {
+		return d.limitError("depth", int64(max))
+	}
+	return nil
+}
+
+func (d *decodeState) leave() {
+	d.depth--
+}
+
+// addElement is called for each list element and dict entry
+func (d *decodeState) addElement() error {
+	d.elements++
+	if d.maxElements > 0 && d.elements > d.maxElements {
+		return d.limitError("element count", d.maxElements)
+	}
+	return nil
+}
+
+func (d *decodeState) syntaxError(format string, a ...interface{}) error {
+	return &SyntaxError{msg: fmt.Sprintf(format, a...), Offset: d.offset}
+}
+
+// readError converts the EOF inside of a value into syntax error
+func (d *decodeState) readError(what string, err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return d.syntaxError("unexpected EOF in %s", what)
+	}
+	return err
+}
+
+// typeError fills the offset and path into *UnmarshalTypeError
+func (d *decodeState) typeError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *UnmarshalTypeError
+	if errors.As(err, &e) && e.Offset == 0 {
+		e.Offset = d.offset
+		e.Path = d.pathString()
+	}
+	return err
 }
 
-func decode(r io.Reader, key string, v reflect.Value) error {
-	var ch [1]byte
-	_, err := r.Read(ch[:])
+// pathStep is a dict key or a list index on the path of decoding value
+type pathStep struct {
+	key   string
+	index int // -1 for dict key
+}
+
+func (d *decodeState) pathString() string {
+	var buf strings.Builder
+	for i, step := range d.path {
+		if step.index >= 0 {
+			buf.WriteString("[" + strconv.Itoa(step.index) + "]")
+			continue
+		}
+		if i > 0 {
+			buf.WriteByte('.')
+		}
+		buf.WriteString(step.key)
+	}
+	return buf.String()
+}
+
+func (d *decodeState) pushKey(key string) {
+	d.path = append(d.path, pathStep{key: key, index: -1})
+}
+
+func (d *decodeState) pushIndex(i int) {
+	d.path = append(d.path, pathStep{index: i})
+}
+
+func (d *decodeState) pop() {
+	d.path = d.path[:len(d.path)-1]
+}
+
+func decode(d *decodeState, v reflect.Value) error {
+	ch, err := d.readByte()
 	if err != nil {
 		return err
 	}
-	switch ch[0] {
+	return decodeValue(d, ch, v)
+}
+
+func decodeValue(d *decodeState, ch byte, v reflect.Value) error {
+	if t := v.Type(); t == valueType || t == reflect.PtrTo(valueType) {
+		// decoded with the options of d instead of UnmarshalBencode
+		value, err := parseValue(d, ch)
+		if err != nil {
+			return err
+		}
+		indirect(v).Set(reflect.ValueOf(value))
+		return nil
+	}
+	if u := getUnmarshaler(v); u != nil {
+		data, err := readRaw(d, ch)
+		if err != nil {
+			return err
+		}
+		if u, ok := u.(optionsUnmarshaler); ok {
+			return u.UnmarshalBencodeOptions(data, DecodeOptions{
+				AllowNonCanonical: !d.strict,
+				UseBigInt:         d.useBigInt,
+				NoCopy:            d.noCopy,
+			})
+		}
+		return u.UnmarshalBencode(data)
+	}
+	if u := getStringUnmarshaler(v); u != nil {
+		return decodeStringUnmarshaler(d, ch, v, u)
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decodeValue(d, ch, v.Elem())
+	}
+	switch ch {
 	case 'i':
-		n, err := parseNumber(r)
+		n, err := parseNumber(d)
 		if err != nil {
 			return err
 		}
-		return setNumber(n, key, v)
+		return d.typeError(setNumber(n, v))
 	case 'd':
-		return decodeDict(r, v)
+		return decodeDict(d, v)
 	case 'l':
-		return decodeList(r, v)
+		return decodeList(d, v)
 	default:
-		str, err := parseString(r, ch[0])
+		if d.r == nil && v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			size, err := parseStringSize(d, ch)
+			if err != nil {
+				return err
+			}
+			data, err := d.next(size)
+			if err != nil {
+				return d.readError("string", err)
+			}
+			if !d.noCopy {
+				data = append(make([]byte, 0, len(data)), data...)
+			}
+			v.SetBytes(data)
+			return nil
+		}
+		str, err := parseString(d, ch)
 		if err != nil {
 			return err
 		}
-		return setString(str, key, v)
+		return d.typeError(setString(str, v))
+	}
+}
+
+// decodeStringUnmarshaler decode the string started with ch by
+// UnmarshalText or UnmarshalBinary of v
+func decodeStringUnmarshaler(d *decodeState, ch byte, v reflect.Value, u func([]byte) error) error {
+	if ch == 'i' || ch == 'd' || ch == 'l' {
+		what := map[byte]string{'i': "number", 'd': "dict", 'l': "list"}[ch]
+		return d.typeError(&UnmarshalTypeError{Value: what, Type: v.Type()})
+	}
+	str, err := parseString(d, ch)
+	if err != nil {
+		return err
 	}
+	return u([]byte(str))
+}
+
+// readRaw read the whole value started with ch and returns its raw bytes
+func readRaw(d *decodeState, ch byte) ([]byte, error) {
+	if d.r == nil {
+		start := d.offset - 1
+		err := skipValue(d, ch)
+		if err != nil {
+			return nil, err
+		}
+		data := d.data[start:d.offset:d.offset]
+		if d.noCopy {
+			return data, nil
+		}
+		return append([]byte(nil), data...), nil
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(ch)
+	d.raw = &buf
+	err := skipValue(d, ch)
+	d.raw = nil
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
 
 type number struct {
 	signed   int64
 	unsigned uint64
+	// big is not nil when the number overflows int64
+	big       *big.Int
+	useBigInt bool
+}
+
+// value returns the number for interface{} target
+func (n number) value() (reflect.Value, error) {
+	if n.big == nil {
+		return reflect.ValueOf(int(n.signed)), nil
+	}
+	if !n.useBigInt {
+		return reflect.Value{}, &UnmarshalTypeError{
+			Value: "number " + n.big.String(),
+			Type:  reflect.TypeOf((*interface{})(nil)).Elem(),
+		}
+	}
+	return reflect.ValueOf(new(big.Int).Set(n.big)), nil
 }
 
-func parseNumber(r io.Reader) (number, error) {
-	var ret number
-	var str []byte
+// bigInt returns the number as *big.Int
+func (n number) bigInt() *big.Int {
+	if n.big != nil {
+		return n.big
+	}
+	return big.NewInt(n.signed)
+}
+
+func parseNumber(d *decodeState) (number, error) {
+	ret := number{useBigInt: d.useBigInt}
+	var buf [24]byte
+	str := buf[:0]
 	for {
-		var ch [1]byte
-		_, err := r.Read(ch[:])
+		ch, err := d.readByte()
 		if err != nil {
-			return ret, fmt.Errorf("parse number: %v", err)
+			return ret, d.readError("number", err)
 		}
-		if ch[0] == 'e' {
+		if ch == 'e' {
+			err = checkNumber(str, d.strict)
+			if err != nil {
+				return ret, d.syntaxError("%v", err)
+			}
 			ret.signed, err = strconv.ParseInt(string(str), 10, 64)
 			if err != nil {
-				return ret, fmt.Errorf("can not parse %s to signed number", string(str))
+				if !errors.Is(err, strconv.ErrRange) {
+					return ret, d.syntaxError("can not parse %s to signed number", string(str))
+				}
+				ret.big = new(big.Int)
+				ret.big.SetString(string(str), 10)
 			}
 			if str[0] != '-' {
 				ret.unsigned, err = strconv.ParseUint(string(str), 10, 64)
-				if err != nil {
-					return ret, fmt.Errorf("can not parse %s to unsigned number", string(str))
+				if err != nil && ret.big == nil {
+					return ret, d.syntaxError("can not parse %s to unsigned number", string(str))
 				}
-			} else {
-				ret.unsigned = uint64(ret.signed)
 			}
 			return ret, nil
 		}
-		str = append(str, ch[0])
+		str = append(str, ch)
+	}
+}
+
+// checkNumber check the syntax of number between i and e,
+// leading zeros and negative zero are only allowed in non-strict mode
+func checkNumber(str []byte, strict bool) error {
+	if len(str) == 0 {
+		return errors.New("empty number")
+	}
+	if !strict {
+		return nil
+	}
+	digits := str
+	if str[0] == '-' {
+		digits = str[1:]
+	}
+	if len(digits) == 0 {
+		return fmt.Errorf("invalid number: %s", string(str))
+	}
+	for _, ch := range digits {
+		if ch < '0' || ch > '9' {
+			return fmt.Errorf("invalid character %q in number: %s", ch, string(str))
+		}
 	}
+	if digits[0] == '0' {
+		if len(digits) > 1 {
+			return fmt.Errorf("leading zero in number: %s", string(str))
+		}
+		if str[0] == '-' {
+			return fmt.Errorf("negative zero in number: %s", string(str))
+		}
+	}
+	return nil
+}
+
+// smallString is the size of string which is allocated at once,
+// larger strings grow with the input to avoid huge allocation by
+// a forged size
+const smallString = 1 << 20
+
+// readString reads the string of size, it aliases data when decoding
+// data, so the caller must copy it unless noCopy
+func readString(d *decodeState, size uint64) ([]byte, error) {
+	if d.r == nil {
+		return d.next(size)
+	}
+	if size <= smallString {
+		data := make([]byte, size)
+		_, err := io.ReadFull(d, data)
+		return data, err
+	}
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, d, int64(size))
+	if err == io.EOF && uint64(n) < size {
+		err = io.ErrUnexpectedEOF
+	}
+	return buf.Bytes(), err
 }
 
-func parseString(r io.Reader, ch byte) (string, error) {
-	var len []byte
-	len = append(len, ch)
+func parseString(d *decodeState, ch byte) (string, error) {
+	size, err := parseStringSize(d, ch)
+	if err != nil {
+		return "", err
+	}
+	data, err := readString(d, size)
+	if err != nil {
+		return "", d.readError("string", err)
+	}
+	return string(data), nil
+}
+
+// parseStringSize parse the size before colon, ch is the first digit
+func parseStringSize(d *decodeState, ch byte) (uint64, error) {
+	var buf [24]byte
+	len := append(buf[:0], ch)
 	for {
-		var ch [1]byte
-		_, err := r.Read(ch[:])
+		ch, err := d.readByte()
 		if err != nil {
-			return "", fmt.Errorf("parse string: %v", err)
+			return 0, d.readError("string size", err)
 		}
-		if ch[0] == ':' {
+		if ch == ':' {
+			if d.strict && len[0] == '0' && string(len) != "0" {
+				return 0, d.syntaxError("leading zero in string size: %s", string(len))
+			}
 			size, err := strconv.ParseUint(string(len), 10, 64)
 			if err != nil {
-				return "", fmt.Errorf("can not parse string size: %s", string(len))
+				return 0, d.syntaxError("can not parse string size: %s", string(len))
 			}
-			data := make([]byte, size)
-			_, err = io.ReadFull(r, data)
-			if err != nil {
-				return "", fmt.Errorf("parse string value: %v", err)
+			if size > math.MaxInt64 {
+				return 0, d.syntaxError("string size too large: %s", string(len))
+			}
+			if d.maxString > 0 && size > uint64(d.maxString) {
+				return 0, d.limitError("string length", d.maxString)
+			}
+			if d.maxBytes > 0 && size > uint64(d.maxBytes-(d.offset-d.start)) {
+				return 0, d.limitError("total bytes", d.maxBytes)
 			}
-			return string(data), nil
+			return size, nil
 		}
-		len = append(len, ch[0])
+		len = append(len, ch)
 	}
 }
 
-func decodeDict(r io.Reader, v reflect.Value) error {
-	for {
-		var ch [1]byte
-		_, err := r.Read(ch[:])
+// checkDictKey check the order of dict keys in strict mode
+func checkDictKey(d *decodeState, prev, key string) error {
+	if !d.strict {
+		return nil
+	}
+	if key == prev {
+		return d.syntaxError("duplicate dict key: %q", key)
+	}
+	if key < prev {
+		return d.syntaxError("dict key %q is not sorted after %q", key, prev)
+	}
+	return nil
+}
+
+// skipValue read and discard the whole value started with ch
+func skipValue(d *decodeState, ch byte) error {
+	switch ch {
+	case 'i':
+		_, err := parseNumber(d)
+		return err
+	case 'd', 'l':
+		err := d.enter()
 		if err != nil {
-			return fmt.Errorf("decode dict: %v", err)
+			return err
 		}
-		if ch[0] == 'e' {
-			return nil
+		defer d.leave()
+		what := "list"
+		if ch == 'd' {
+			what = "dict"
 		}
-		key, err := parseString(r, ch[0])
-		var target reflect.Value
-		switch v.Kind() {
-		case reflect.Interface, reflect.Map:
-			if v.IsNil() {
-				if v.Type().Kind() == reflect.Interface {
-					value := reflect.MakeMap(reflect.TypeOf(map[string]interface{}{}))
-					v.Set(value)
-				} else {
-					value := reflect.MakeMap(v.Type())
-					v.Set(value)
+		var prev string
+		for i := 0; ; i++ {
+			next, err := d.readByte()
+			if err != nil {
+				return d.readError(what, err)
+			}
+			if next == 'e' {
+				return nil
+			}
+			err = d.addElement()
+			if err != nil {
+				return err
+			}
+			if ch == 'l' {
+				err = skipValue(d, next)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			key, err := parseString(d, next)
+			if err != nil {
+				return err
+			}
+			if i > 0 {
+				err = checkDictKey(d, prev, key)
+				if err != nil {
+					return err
 				}
 			}
-			target = v
-		case reflect.Struct:
-			target = getDictStructTarget(v, key, notfoundType)
+			prev = key
+			next, err = d.readByte()
+			if err != nil {
+				return d.readError("dict", err)
+			}
+			err = skipValue(d, next)
+			if err != nil {
+				return err
+			}
 		}
-		err = decode(r, key, target)
+	default:
+		size, err := parseStringSize(d, ch)
 		if err != nil {
 			return err
 		}
+		if d.r == nil {
+			_, err = d.next(size)
+			if err != nil {
+				return d.readError("string", err)
+			}
+			return nil
+		}
+		n, err := io.CopyN(ioutil.Discard, d, int64(size))
+		if err == io.EOF && uint64(n) < size {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return d.readError("string", err)
+		}
+		return nil
 	}
 }
 
-func decodeList(r io.Reader, v reflect.Value) error {
-	slice := reflect.MakeSlice(reflect.TypeOf([]interface{}{}), 0, 0)
-	reset := false
-	for {
-		var ch [1]byte
-		_, err := r.Read(ch[:])
+func decodeDict(d *decodeState, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		return decodeDict(d, indirect(v))
+	case reflect.Interface:
+		if v.NumMethod() > 0 {
+			return d.typeError(&UnmarshalTypeError{Value: "dict", Type: v.Type()})
+		}
+		if v.IsNil() || v.Elem().Kind() != reflect.Map {
+			v.Set(reflect.ValueOf(map[string]interface{}{}))
+		}
+		return decodeDict(d, v.Elem())
+	case reflect.Map:
+		if !isMapKeyType(v.Type().Key()) {
+			return d.typeError(&UnmarshalTypeError{Value: "dict", Type: v.Type()})
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+	case reflect.Struct:
+		if v.Type() == notfoundType {
+			return skipValue(d, 'd')
+		}
+	default:
+		return d.typeError(&UnmarshalTypeError{Value: "dict", Type: v.Type()})
+	}
+	err := d.enter()
+	if err != nil {
+		return err
+	}
+	defer d.leave()
+	var prev string
+	for i := 0; ; i++ {
+		ch, err := d.readByte()
+		if err != nil {
+			return d.readError("dict", err)
+		}
+		if ch == 'e' {
+			return nil
+		}
+		err = d.addElement()
 		if err != nil {
-			return fmt.Errorf("decode dict: %v", err)
+			return err
 		}
-		if ch[0] == 'e' {
-			return setList(v, slice)
+		key, err := parseString(d, ch)
+		if err != nil {
+			return err
 		}
-		switch ch[0] {
-		case 'i':
-			n, err := parseNumber(r)
+		if i > 0 {
+			err = checkDictKey(d, prev, key)
 			if err != nil {
 				return err
 			}
-			slice, err = appendNumber(n, slice)
-		case 'd':
-			if v.Type() != notfoundType &&
-				v.Type().Elem().Kind() != reflect.Interface &&
-				!reset {
-				slice = reflect.MakeSlice(v.Type(), 0, 0)
-				reset = true
+		}
+		prev = key
+		d.pushKey(key)
+		err = decodeDictValue(d, key, v)
+		if err != nil {
+			return err
+		}
+		d.pop()
+	}
+}
+
+// decodeDictValue decode the value of key into the struct field or
+// the map element
+func decodeDictValue(d *decodeState, key string, v reflect.Value) error {
+	ch, err := d.readByte()
+	if err != nil {
+		return d.readError("dict", err)
+	}
+	if v.Kind() == reflect.Struct {
+		return decodeValue(d, ch, getDictStructTarget(v, key, notfoundType))
+	}
+	k, err := mapKey(v.Type().Key(), key)
+	if err != nil {
+		return err
+	}
+	elem := reflect.New(v.Type().Elem()).Elem()
+	err = decodeValue(d, ch, elem)
+	if err != nil {
+		return err
+	}
+	v.SetMapIndex(k, elem)
+	return nil
+}
+
+// decodeList decode the list into slice or array by its element type,
+// or into []interface{} for interface{}
+func decodeList(d *decodeState, v reflect.Value) error {
+	if v.Type() == notfoundType {
+		return skipValue(d, 'l')
+	}
+	var slice reflect.Value
+	switch v.Kind() {
+	case reflect.Slice:
+		slice = reflect.MakeSlice(v.Type(), 0, 0)
+	case reflect.Interface:
+		if v.NumMethod() > 0 {
+			return d.typeError(&UnmarshalTypeError{Value: "list", Type: v.Type()})
+		}
+		slice = reflect.MakeSlice(reflect.TypeOf([]interface{}{}), 0, 0)
+	case reflect.Array:
+	default:
+		return d.typeError(&UnmarshalTypeError{Value: "list", Type: v.Type()})
+	}
+	err := d.enter()
+	if err != nil {
+		return err
+	}
+	defer d.leave()
+	for i := 0; ; i++ {
+		ch, err := d.readByte()
+		if err != nil {
+			return d.readError("list", err)
+		}
+		if ch == 'e' {
+			if v.Kind() != reflect.Array {
+				v.Set(slice)
+				return nil
+			}
+			zero := reflect.Zero(v.Type().Elem())
+			for ; i < v.Len(); i++ {
+				v.Index(i).Set(zero)
 			}
-			slice, err = appendDict(r, slice)
-		case 'l':
-			slice, err = appendList(r, slice)
-		default:
-			str, err := parseString(r, ch[0])
-			if err != nil {
-				return err
+			return nil
+		}
+		err = d.addElement()
+		if err != nil {
+			return err
+		}
+		d.pushIndex(i)
+		if v.Kind() == reflect.Array {
+			if i >= v.Len() {
+				return d.typeError(&UnmarshalTypeError{
+					Value: "list longer than " + strconv.Itoa(v.Len()),
+					Type:  v.Type(),
+				})
 			}
-			slice, err = appendString(str, slice)
+			err = decodeValue(d, ch, v.Index(i))
+		} else {
+			elem := reflect.New(slice.Type().Elem()).Elem()
+			err = decodeValue(d, ch, elem)
+			slice = reflect.Append(slice, elem)
 		}
 		if err != nil {
 			return err
 		}
+		d.pop()
 	}
 }
-
-// func decodeList2Slice(r *bufio.Reader, v reflect.Value) error {
-// 	slice := reflect.MakeSlice(v.Type(), 0, 0)
-// 	for {
-// 		ch, err := r.ReadByte()
-// 		if err != nil {
-// 			return fmt.Errorf("decode slice: %v", err)
-// 		}
-// 		if ch == 'e' {
-// 			break
-// 		}
-// 		v := reflect.New(v.Type().Elem())
-// 		switch ch {
-// 		case 'i':
-// 			n, err := decodeNumber(r, v)
-// 			if err != nil {
-// 				return err
-// 			}
-// 			if n.isUnsigned {
-// 				v.Elem().SetUint(n.unsigned)
-// 			} else {
-// 				v.Elem().SetInt(n.signed)
-// 			}
-// 		case 'd':
-// 			err = decodeDict(r, v.Elem())
-// 			if err != nil {
-// 				return err
-// 			}
-// 		case 'l':
-// 			err = decodeList(r, v.Elem())
-// 			if err != nil {
-// 				return err
-// 			}
-// 		default:
-// 			err = decodeString(r, v.Elem(), ch)
-// 			if err != nil {
-// 				return err
-// 			}
-// 		}
-// 		slice = reflect.Append(slice, v.Elem())
-// 	}
-// 	v.Set(slice)
-// 	return nil
-// }
-
-// func decodeList2Array(r *bufio.Reader, v reflect.Value) error {
-// 	i := 0
-// 	for {
-// 		ch, err := r.ReadByte()
-// 		if err != nil {
-// 			return fmt.Errorf("decode slice: %v", err)
-// 		}
-// 		if ch == 'e' {
-// 			break
-// 		}
-// 		target := v.Index(i)
-// 		switch ch {
-// 		case 'i':
-// 			n, err := decodeNumber(r, target)
-// 			if err != nil {
-// 				return err
-// 			}
-// 			if n.isUnsigned {
-// 				target.SetUint(n.unsigned)
-// 			} else {
-// 				target.SetInt(n.signed)
-// 			}
-// 		case 'd':
-// 			err = decodeDict(r, target)
-// 			if err != nil {
-// 				return err
-// 			}
-// 		case 'l':
-// 			err = decodeList(r, target)
-// 			if err != nil {
-// 				return err
-// 			}
-// 		default:
-// 			err = decodeString(r, target, ch)
-// 			if err != nil {
-// 				return err
-// 			}
-// 		}
-// 		i++
-// 		if i >= v.Len() {
-// 			break
-// 		}
-// 	}
-// 	return nil
-// }
-
-// func decodeList2Interface(r *bufio.Reader, v reflect.Value) error {
-// 	var t reflect.Type
-// 	if !v.IsNil() {
-// 		t = v.Elem().Type()
-// 	}
-// 	var slice reflect.Value
-// 	for {
-// 		ch, err := r.ReadByte()
-// 		if err != nil {
-// 			return fmt.Errorf("decode slice: %v", err)
-// 		}
-// 		if ch == 'e' {
-// 			break
-// 		}
-// 		var value reflect.Value
-// 		if t.Kind() != reflect.Invalid {
-// 			value = reflect.New(t)
-// 		}
-// 		switch ch {
-// 		case 'i':
-// 			if !value.IsValid() {
-// 				value = reflect.New(reflect.TypeOf(0))
-// 			}
-// 			n, err := decodeNumber(r, value)
-// 			if err != nil {
-// 				return err
-// 			}
-// 			if n.isUnsigned {
-// 				value.Elem().SetUint(n.unsigned)
-// 			} else {
-// 				value.Elem().SetInt(n.signed)
-// 			}
-// 		case 'd':
-// 			err = decodeDict(r, v.Elem())
-// 			if err != nil {
-// 				return err
-// 			}
-// 		case 'l':
-// 			err = decodeList(r, v.Elem())
-// 			if err != nil {
-// 				return err
-// 			}
-// 		default:
-// 			err = decodeString(r, v.Elem(), ch)
-// 			if err != nil {
-// 				return err
-// 			}
-// 		}
-// 		slice = reflect.Append(slice, v.Elem())
-// 	}
-// 	v.Set(slice)
-// 	return nil
-// }
-
-// func decodeList(r *bufio.Reader, v reflect.Value) error {
-// 	if v.Kind() == reflect.Interface {
-// 		return decodeList2Interface(r, v)
-// 	}
-// 	if v.Kind() == reflect.Slice {
-// 		return decodeList2Slice(r, v)
-// 	}
-// 	if v.Kind() == reflect.Array {
-// 		return decodeList2Array(r, v)
-// 	}
-// 	return fmt.Errorf("can not set list value to variable of type %s", v.Kind().String())
-// }
-
-// func setDictNumber(r *bufio.Reader, key string, v reflect.Value) error {
-// 	if v.Kind() == reflect.Map {
-// 		target := getDictTarget(v, key, reflect.TypeOf(0))
-// 		n, err := decodeNumber(r, target)
-// 		if err != nil {
-// 			return err
-// 		}
-// 		v.SetMapIndex(reflect.ValueOf(key), numberByType(n, target.Type()))
-// 		return nil
-// 	}
-// 	target := getDictTarget(v, key, reflect.TypeOf(0))
-// 	n, err := decodeNumber(r, target)
-// 	if err != nil {
-// 		return err
-// 	}
-// 	if n.isUnsigned {
-// 		target.SetUint(n.unsigned)
-// 	} else {
-// 		target.SetInt(n.signed)
-// 	}
-// 	return nil
-// }
-
-// func setDictString(r *bufio.Reader, key string, v reflect.Value, ch byte) error {
-// 	if v.Kind() == reflect.Map {
-// 		target := getDictTarget(v, key, reflect.TypeOf(""))
-// 		err := decodeString(r, target, ch)
-// 		if err != nil {
-// 			return err
-// 		}
-// 		v.SetMapIndex(reflect.ValueOf(key), target)
-// 		return nil
-// 	}
-// 	target := getDictTarget(v, key, reflect.TypeOf(""))
-// 	return decodeString(r, target, ch)
-// }
-
-// func setDictDict(r *bufio.Reader, key string, v reflect.Value) error {
-// 	if v.Kind() == reflect.Map {
-// 		target := getDictTarget(v, key, reflect.TypeOf(map[string]interface{}{}))
-// 		err := decodeDict(r, target)
-// 		if err != nil {
-// 			return err
-// 		}
-// 		v.SetMapIndex(reflect.ValueOf(key), target)
-// 		return nil
-// 	}
-// 	target := getDictTarget(v, key, reflect.TypeOf(map[string]interface{}{}))
-// 	return decodeDict(r, target)
-// }
-
-// func setDictList(r *bufio.Reader, key string, v reflect.Value) error {
-// 	if v.Kind() == reflect.Map {
-// 		target := getDictTarget(v, key, reflect.TypeOf([]interface{}{}))
-// 		err := decodeList(r, target)
-// 		if err != nil {
-// 			return err
-// 		}
-// 		v.SetMapIndex(reflect.ValueOf(key), target)
-// 		return nil
-// 	}
-// 	target := getDictTarget(v, key, reflect.TypeOf([]interface{}{}))
-// 	return decodeDict(r, target)
-// }
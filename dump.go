@@ -0,0 +1,177 @@
+package bencode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DumpOptions controls the output of Dump
+type DumpOptions struct {
+	Prefix string // prefix of each line
+	Indent string // indent of each level, default is two spaces
+	// MaxBinary is the max count of bytes shown for binary string,
+	// the rest are truncated, 0 for no limit
+	MaxBinary int
+	// Hashes shows 20-byte binary strings as hash
+	Hashes bool
+	// Peers shows the binary strings of peer keys, such as peers and
+	// values, as compact IPv4 peers of 6-byte multiples, or compact IPv6
+	// peers of 18-byte multiples if the key ends with "6", like peers6
+	Peers bool
+}
+
+// Indent writes the readable text of bencode data into dst, each line
+// begins with prefix followed by copies of indent for the nesting
+func Indent(dst *bytes.Buffer, src []byte, prefix, indent string) error {
+	return Dump(dst, src, DumpOptions{Prefix: prefix, Indent: indent})
+}
+
+// Dump writes the readable text of bencode data into w, printable
+// strings are quoted and binary strings are shown as hex, the data is
+// decoded without canonical checking, so it can be used for the data
+// failed to decode
+func Dump(w io.Writer, src []byte, opt DumpOptions) error {
+	if opt.Indent == "" {
+		opt.Indent = "  "
+	}
+	var v Value
+	dec := NewBytesDecoder(src)
+	dec.AllowNonCanonical()
+	err := dec.Decode(&v)
+	if err != nil {
+		return err
+	}
+	err = dec.endError(len(src))
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	buf.WriteString(opt.Prefix)
+	dumpValue(&buf, v, "", opt, 0)
+	buf.WriteByte('\n')
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// peerKeys are the dict keys of compact peers, values is the list of
+// compact peers in DHT get_peers response, added and dropped are the
+// peers of PEX
+var peerKeys = map[string]bool{
+	"peers":    true,
+	"peers6":   true,
+	"values":   true,
+	"added":    true,
+	"added6":   true,
+	"dropped":  true,
+	"dropped6": true,
+}
+
+func dumpNewline(buf *bytes.Buffer, opt DumpOptions, depth int) {
+	buf.WriteByte('\n')
+	buf.WriteString(opt.Prefix)
+	buf.WriteString(strings.Repeat(opt.Indent, depth))
+}
+
+func dumpValue(buf *bytes.Buffer, v Value, key string, opt DumpOptions, depth int) {
+	switch v.Kind() {
+	case IntKind:
+		buf.WriteString(v.num)
+	case StringKind:
+		dumpString(buf, v.str, key, opt)
+	case ListKind:
+		if len(v.list) == 0 {
+			buf.WriteString("[]")
+			return
+		}
+		buf.WriteByte('[')
+		for _, item := range v.list {
+			dumpNewline(buf, opt, depth+1)
+			// the items are shown by the dict key of list, like values
+			dumpValue(buf, item, key, opt, depth+1)
+		}
+		dumpNewline(buf, opt, depth)
+		buf.WriteByte(']')
+	case DictKind:
+		if len(v.dict) == 0 {
+			buf.WriteString("{}")
+			return
+		}
+		buf.WriteByte('{')
+		for _, entry := range v.dict {
+			dumpNewline(buf, opt, depth+1)
+			dumpString(buf, []byte(entry.Key), "", DumpOptions{MaxBinary: opt.MaxBinary})
+			buf.WriteString(": ")
+			dumpValue(buf, entry.Value, entry.Key, opt, depth+1)
+		}
+		dumpNewline(buf, opt, depth)
+		buf.WriteByte('}')
+	}
+}
+
+func isPrintable(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, r := range string(data) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// dumpString writes the printable string as quoted, or the binary
+// string as <N bytes: hex>, key is the dict key of the string
+func dumpString(buf *bytes.Buffer, data []byte, key string, opt DumpOptions) {
+	if isPrintable(data) {
+		buf.WriteString(strconv.Quote(string(data)))
+		return
+	}
+	if opt.Hashes && len(data) == 20 {
+		buf.WriteString("<hash " + hex.EncodeToString(data) + ">")
+		return
+	}
+	if opt.Peers && peerKeys[key] {
+		size := net.IPv4len
+		if strings.HasSuffix(key, "6") ||
+			(key == "values" && len(data) == net.IPv6len+2) {
+			size = net.IPv6len
+		}
+		if len(data)%(size+2) == 0 {
+			dumpPeers(buf, data, size)
+			return
+		}
+	}
+	show := data
+	if opt.MaxBinary > 0 && len(show) > opt.MaxBinary {
+		show = show[:opt.MaxBinary]
+	}
+	fmt.Fprintf(buf, "<%d bytes: %x", len(data), show)
+	if len(show) < len(data) {
+		buf.WriteString("...")
+	}
+	buf.WriteByte('>')
+}
+
+// dumpPeers writes the compact peers, each peer is the ip in size bytes
+// followed by the port in 2 bytes
+func dumpPeers(buf *bytes.Buffer, data []byte, size int) {
+	buf.WriteString("<peers")
+	for i := 0; i < len(data); i += size + 2 {
+		ip := net.IP(data[i : i+size])
+		port := binary.BigEndian.Uint16(data[i+size:])
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(" " + net.JoinHostPort(ip.String(), strconv.Itoa(int(port))))
+	}
+	buf.WriteByte('>')
+}
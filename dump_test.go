@@ -0,0 +1,91 @@
+package bencode
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	str := "d8:announce3:url4:infod6:lengthi1e6:pieces20:\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f\x10\x11\x12\x13\x14e5:peers6:\x7f\x00\x00\x01\x1a\xe14:listl3:a\"bi-1eleee"
+	var buf bytes.Buffer
+	err := Indent(&buf, []byte(str), "", "  ")
+	if err != nil {
+		t.Fatalf("FATAL: indent: %v", err)
+	}
+	want := `{
+  "announce": "url"
+  "info": {
+    "length": 1
+    "pieces": <20 bytes: 0102030405060708090a0b0c0d0e0f1011121314>
+  }
+  "peers": <6 bytes: 7f0000011ae1>
+  "list": [
+    "a\"b"
+    -1
+    []
+  ]
+}
+`
+	if buf.String() != want {
+		t.Fatalf("unexpected indent: %s", buf.String())
+	}
+
+	buf.Reset()
+	err = Dump(&buf, []byte(str), DumpOptions{Prefix: "> ", Indent: "\t", MaxBinary: 4, Hashes: true, Peers: true})
+	if err != nil {
+		t.Fatalf("FATAL: dump: %v", err)
+	}
+	want = `> {
+> 	"announce": "url"
+> 	"info": {
+> 		"length": 1
+> 		"pieces": <hash 0102030405060708090a0b0c0d0e0f1011121314>
+> 	}
+> 	"peers": <peers 127.0.0.1:6881>
+> 	"list": [
+> 		"a\"b"
+> 		-1
+> 		[]
+> 	]
+> }
+`
+	if buf.String() != want {
+		t.Fatalf("unexpected dump: %s", buf.String())
+	}
+
+	buf.Reset()
+	peers6 := "d6:peers618:" + strings.Repeat("\x00", 15) + "\x01\x1a\xe1e"
+	err = Dump(&buf, []byte(peers6), DumpOptions{MaxBinary: 2, Peers: true})
+	if err != nil {
+		t.Fatalf("FATAL: dump peers6: %v", err)
+	}
+	if buf.String() != "{\n  \"peers6\": <peers [::1]:6881>\n}\n" {
+		t.Fatalf("unexpected dump of peers6: %s", buf.String())
+	}
+
+	buf.Reset()
+	values := "d6:valuesl6:\x7f\x00\x00\x01\x1a\xe1e6:pieces12:" + strings.Repeat("\xff", 12) + "e"
+	err = Dump(&buf, []byte(values), DumpOptions{MaxBinary: 2, Peers: true})
+	if err != nil {
+		t.Fatalf("FATAL: dump values: %v", err)
+	}
+	if buf.String() != "{\n  \"values\": [\n    <peers 127.0.0.1:6881>\n  ]\n  \"pieces\": <12 bytes: ffff...>\n}\n" {
+		t.Fatalf("unexpected dump of values: %s", buf.String())
+	}
+
+	buf.Reset()
+	err = Dump(&buf, []byte("3:\xff\xff\xff"), DumpOptions{MaxBinary: 2})
+	if err != nil {
+		t.Fatalf("FATAL: dump binary: %v", err)
+	}
+	if buf.String() != "<3 bytes: ffff...>\n" {
+		t.Fatalf("unexpected dump of binary: %s", buf.String())
+	}
+
+	for _, str := range []string{"d1:a", "i1ee", "i1ei2e"} {
+		if Indent(&buf, []byte(str), "", "  ") == nil {
+			t.Fatalf("expected error of %q", str)
+		}
+	}
+}
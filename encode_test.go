@@ -2,8 +2,12 @@ package bencode
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"math/big"
+	"net"
 	"testing"
+	"time"
 )
 
 func TestEncodeNumber(t *testing.T) {
@@ -51,7 +55,7 @@ func TestEncodeString(t *testing.T) {
 	}
 
 	bs := []byte(str)
-	data, err = Encode(str)
+	data, err = Encode(bs)
 	if err != nil {
 		t.Fatalf("FATAL: encode bytes: %v", err)
 	}
@@ -80,6 +84,24 @@ func TestEncodeByteArray(t *testing.T) {
 	if !bytes.Equal(data, []byte("3:abc")) {
 		t.Fatal("unexpected byte array value")
 	}
+	data, err = Encode([0]byte{})
+	if err != nil {
+		t.Fatalf("FATAL: encode empty byte array: %v", err)
+	}
+	if !bytes.Equal(data, []byte("0:")) {
+		t.Fatalf("unexpected empty byte array value: %s", string(data))
+	}
+	err = NewEncoder(errWriter{}).Encode(id)
+	if err == nil {
+		t.Fatal("expected error of failed write")
+	}
+}
+
+// errWriter fails every write
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write failed")
 }
 
 func TestEncodeList(t *testing.T) {
@@ -247,3 +269,300 @@ func TestEncodeByte(t *testing.T) {
 		t.Fatalf("unexpected value: %s", string(data))
 	}
 }
+
+func TestEncodeSortedKeys(t *testing.T) {
+	mp := map[string]interface{}{
+		"c": 3,
+		"a": 1,
+		"b": "abc",
+		"B": 2,
+	}
+	data, err := Encode(mp)
+	if err != nil {
+		t.Fatalf("FATAL: encode map: %v", err)
+	}
+	if !bytes.Equal(data, []byte("d1:Bi2e1:ai1e1:b3:abc1:ci3ee")) {
+		t.Fatalf("unexpected map value: %s", string(data))
+	}
+
+	type hdr struct {
+		Transaction string `bencode:"t"`
+		Type        string `bencode:"y"`
+	}
+	type query struct {
+		hdr
+		Query string `bencode:"q"`
+		Args  struct {
+			ID     string `bencode:"id"`
+			Target string `bencode:"target"`
+		} `bencode:"a"`
+	}
+	var q query
+	q.Transaction = "aa"
+	q.Type = "q"
+	q.Query = "find_node"
+	q.Args.ID = "abc"
+	q.Args.Target = "def"
+	data, err = Encode(q)
+	if err != nil {
+		t.Fatalf("FATAL: encode struct: %v", err)
+	}
+	target := "d1:ad2:id3:abc6:target3:defe1:q9:find_node1:t2:aa1:y1:qe"
+	if !bytes.Equal(data, []byte(target)) {
+		t.Fatalf("unexpected struct value: %s", string(data))
+	}
+}
+
+type compactPort uint16
+
+func (p compactPort) MarshalBencode() ([]byte, error) {
+	return Encode(string([]byte{byte(p >> 8), byte(p)}))
+}
+
+func TestEncodeMarshaler(t *testing.T) {
+	data, err := Encode(compactPort(0x3132))
+	if err != nil {
+		t.Fatalf("FATAL: encode marshaler: %v", err)
+	}
+	if !bytes.Equal(data, []byte("2:12")) {
+		t.Fatalf("unexpected marshaler value: %s", string(data))
+	}
+	port := compactPort(0x3334)
+	obj := struct {
+		Port  compactPort            `bencode:"port"`
+		Ptr   *compactPort           `bencode:"ptr"`
+		Ports []compactPort          `bencode:"ports"`
+		Peers map[string]compactPort `bencode:"peers"`
+	}{
+		Port:  0x3132,
+		Ptr:   &port,
+		Ports: []compactPort{0x3536},
+		Peers: map[string]compactPort{"a": 0x3738},
+	}
+	data, err = Encode(obj)
+	if err != nil {
+		t.Fatalf("FATAL: encode marshaler struct: %v", err)
+	}
+	target := "d5:peersd1:a2:78e4:port2:125:portsl2:56e3:ptr2:34e"
+	if !bytes.Equal(data, []byte(target)) {
+		t.Fatalf("unexpected marshaler struct value: %s", string(data))
+	}
+}
+
+func TestEncodeTagOptions(t *testing.T) {
+	type metainfo struct {
+		Announce  string `bencode:"announce"`
+		Comment   string `bencode:"comment,omitempty"`
+		CreatedBy string `bencode:"created by,omitempty"`
+		Date      int64  `bencode:"creation date,omitempty"`
+		Private   *int   `bencode:",omitempty"`
+		Cache     string `bencode:"-"`
+		Dash      string `bencode:"-,"`
+	}
+	obj := metainfo{
+		Announce: "abc",
+		Cache:    "def",
+		Dash:     "efg",
+	}
+	data, err := Encode(obj)
+	if err != nil {
+		t.Fatalf("FATAL: encode tag options: %v", err)
+	}
+	if !bytes.Equal(data, []byte("d1:-3:efg8:announce3:abce")) {
+		t.Fatalf("unexpected tag options value: %s", string(data))
+	}
+	private := 1
+	obj.Comment = "a"
+	obj.Date = 1
+	obj.Private = &private
+	data, err = Encode(obj)
+	if err != nil {
+		t.Fatalf("FATAL: encode tag options: %v", err)
+	}
+	target := "d1:-3:efg8:announce3:abc7:comment1:a13:creation datei1e7:privatei1ee"
+	if !bytes.Equal(data, []byte(target)) {
+		t.Fatalf("unexpected tag options value: %s", string(data))
+	}
+}
+
+func TestEncodeVisibility(t *testing.T) {
+	type Hdr struct {
+		Transaction string `bencode:"t"`
+	}
+	type query struct {
+		Action string `bencode:"q"`
+	}
+	type request struct {
+		*Hdr
+		query
+		Type    string `bencode:"y"`
+		private int
+	}
+	obj := request{
+		Hdr:     &Hdr{Transaction: "aa"},
+		query:   query{Action: "ping"},
+		Type:    "q",
+		private: 1,
+	}
+	data, err := Encode(obj)
+	if err != nil {
+		t.Fatalf("FATAL: encode visibility: %v", err)
+	}
+	if !bytes.Equal(data, []byte("d1:q4:ping1:t2:aa1:y1:qe")) {
+		t.Fatalf("unexpected visibility value: %s", string(data))
+	}
+	obj.Hdr = nil
+	data, err = Encode(obj)
+	if err != nil {
+		t.Fatalf("FATAL: encode nil embedded pointer: %v", err)
+	}
+	if !bytes.Equal(data, []byte("d1:q4:ping1:y1:qe")) {
+		t.Fatalf("unexpected nil embedded pointer value: %s", string(data))
+	}
+}
+
+func TestEncodeBigInt(t *testing.T) {
+	n, _ := new(big.Int).SetString("-123456789012345678901234567890", 10)
+	data, err := Encode(n)
+	if err != nil {
+		t.Fatalf("FATAL: encode big int: %v", err)
+	}
+	if !bytes.Equal(data, []byte("i-123456789012345678901234567890e")) {
+		t.Fatalf("unexpected big int value: %s", string(data))
+	}
+	obj := struct {
+		Downloaded big.Int  `bencode:"downloaded"`
+		Uploaded   *big.Int `bencode:"uploaded"`
+	}{
+		Uploaded: n,
+	}
+	obj.Downloaded.SetUint64(18446744073709551615)
+	data, err = Encode(obj)
+	if err != nil {
+		t.Fatalf("FATAL: encode big int struct: %v", err)
+	}
+	target := "d10:downloadedi18446744073709551615e8:uploadedi-123456789012345678901234567890ee"
+	if !bytes.Equal(data, []byte(target)) {
+		t.Fatalf("unexpected big int struct value: %s", string(data))
+	}
+}
+
+func TestEncodeMapKeys(t *testing.T) {
+	type name string
+	data, err := Encode(map[name]string{"b": "1", "a": "2"})
+	if err != nil {
+		t.Fatalf("FATAL: encode named key: %v", err)
+	}
+	if string(data) != "d1:a1:21:b1:1e" {
+		t.Fatalf("unexpected encoded named key: %s", data)
+	}
+	data, err = Encode(map[hexKey]int{{0xff, 0}: 1, {0, 0xff}: 2})
+	if err != nil {
+		t.Fatalf("FATAL: encode text key: %v", err)
+	}
+	if string(data) != "d4:00ffi2e4:ff00i1ee" {
+		t.Fatalf("unexpected encoded text key: %s", data)
+	}
+	_, err = Encode(map[int]int{1: 1})
+	if _, ok := err.(*UnsupportedTypeError); !ok {
+		t.Fatalf("unexpected error of int key: %v", err)
+	}
+}
+
+func TestEncodeTextMarshaler(t *testing.T) {
+	var v struct {
+		IP     net.IP            `bencode:"ip"`
+		Time   time.Time         `bencode:"time"`
+		Hash   revHash           `bencode:"hash"`
+		Big    *big.Int          `bencode:"big"`
+		Peers  map[revHash]int   `bencode:"peers"`
+		Hashes map[string][]byte `bencode:"hashes"`
+	}
+	v.IP = net.IPv4(1, 2, 3, 4)
+	v.Time = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	v.Hash = revHash{'a', 'b', 'c'}
+	v.Big = big.NewInt(42)
+	v.Peers = map[revHash]int{{'x', 'y', 'z'}: 1}
+	v.Hashes = map[string][]byte{"a": []byte("abc")}
+	data, err := Encode(v)
+	if err != nil {
+		t.Fatalf("FATAL: encode marshaler: %v", err)
+	}
+	want := "d3:bigi42e4:hash3:cba6:hashesd1:a3:abce2:ip7:1.2.3.4" +
+		"5:peersd3:zyxi1ee4:time20:2020-01-02T03:04:05Ze"
+	if string(data) != want {
+		t.Fatalf("unexpected encoded marshaler: %s", data)
+	}
+}
+
+func TestEncodeEmbeddedConflict(t *testing.T) {
+	type inner struct {
+		A int
+		C int `bencode:"c"`
+	}
+	type outer struct {
+		*inner
+		C int `bencode:"a"`
+	}
+	// the direct field hides the embedded one
+	data, err := Encode(outer{inner: &inner{A: 1, C: 3}, C: 2})
+	if err != nil {
+		t.Fatalf("FATAL: encode embedded: %v", err)
+	}
+	if string(data) != "d1:ai2e1:ci3ee" || !Valid(data) {
+		t.Fatalf("unexpected encoded embedded: %s", data)
+	}
+
+	type tagged struct {
+		A int `bencode:"a"`
+	}
+	type untagged struct {
+		A int
+		B int
+	}
+	type other struct {
+		B int
+	}
+	// the tagged field hides the untagged one at the same depth, and
+	// the ambiguous key is dropped
+	data, err = Encode(struct {
+		untagged
+		tagged
+		other
+	}{untagged{1, 2}, tagged{3}, other{4}})
+	if err != nil {
+		t.Fatalf("FATAL: encode ambiguous: %v", err)
+	}
+	if string(data) != "d1:ai3ee" {
+		t.Fatalf("unexpected encoded ambiguous: %s", data)
+	}
+
+	// the tag without name does not make the field tagged
+	type options struct {
+		B int `bencode:",omitempty"`
+	}
+	data, err = Encode(struct {
+		options
+		other
+	}{options{1}, other{2}})
+	if err != nil {
+		t.Fatalf("FATAL: encode options: %v", err)
+	}
+	if string(data) != "de" {
+		t.Fatalf("unexpected encoded options: %s", data)
+	}
+}
+
+func TestEncodeBool(t *testing.T) {
+	data, err := Encode(struct {
+		Seed    bool `bencode:"seed"`
+		Private bool `bencode:"private,omitempty"`
+		Flags   []bool
+	}{Seed: true, Flags: []bool{false, true}})
+	if err != nil {
+		t.Fatalf("FATAL: encode bool: %v", err)
+	}
+	if string(data) != "d5:flagsli0ei1ee4:seedi1ee" {
+		t.Fatalf("unexpected encoded bool: %s", data)
+	}
+}
@@ -2,33 +2,57 @@ package bencode
 
 import (
 	"bytes"
-	"fmt"
+	"encoding"
+	"errors"
 	"io"
+	"math/big"
 	"reflect"
-	"strings"
+	"sort"
+	"strconv"
 )
 
 // Encoder bencode encoder
 type Encoder struct {
-	w io.Writer
+	*encoder
+}
+
+// encoder is the state shared by the copies of Encoder
+type encoder struct {
+	w          io.Writer
+	tokens     []writeState
+	sortedKeys bool
+}
+
+// Marshaler is the interface implemented by types that can marshal
+// themselves into valid bencode
+type Marshaler interface {
+	MarshalBencode() ([]byte, error)
 }
 
 var bytesType = reflect.TypeOf([]byte{})
+var marshalerType = reflect.TypeOf((*Marshaler)(nil)).Elem()
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+var binaryMarshalerType = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
 
 // NewEncoder create encoder to io.Writer
 func NewEncoder(w io.Writer) Encoder {
-	return Encoder{w}
+	return Encoder{&encoder{w: w}}
 }
 
-// Encode encode data
+// Encode encode data, it can be mixed with the Write methods
+// to encode a value of list or dict
 func (enc Encoder) Encode(data interface{}) error {
+	err := enc.beginValue()
+	if err != nil {
+		return err
+	}
 	return encode(enc.w, reflect.ValueOf(data))
 }
 
 // Encode encode data in raw
 func Encode(data interface{}) ([]byte, error) {
 	var buf bytes.Buffer
-	err := NewEncoder(&buf).Encode(data)
+	err := encode(&buf, reflect.ValueOf(data))
 	if err != nil {
 		return nil, err
 	}
@@ -74,7 +98,84 @@ func uint64Str(i uint64) string {
 	return string(str)
 }
 
+func getMarshaler(v reflect.Value) Marshaler {
+	if !v.IsValid() {
+		return nil
+	}
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		return nil
+	}
+	if v.Type().Implements(marshalerType) && v.CanInterface() {
+		return v.Interface().(Marshaler)
+	}
+	if v.CanAddr() && v.Addr().CanInterface() &&
+		v.Addr().Type().Implements(marshalerType) {
+		return v.Addr().Interface().(Marshaler)
+	}
+	return nil
+}
+
+// isBigInt reports whether t is big.Int or *big.Int, which is encoded
+// as number instead of its MarshalText
+func isBigInt(t reflect.Type) bool {
+	return t == bigIntType || t == reflect.PtrTo(bigIntType)
+}
+
+// marshalString returns the byte string of v which implements
+// encoding.TextMarshaler or encoding.BinaryMarshaler, ok is false when
+// v implements neither of them
+func marshalString(v reflect.Value) (data []byte, ok bool, err error) {
+	if !v.IsValid() || isBigInt(v.Type()) {
+		return nil, false, nil
+	}
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		return nil, false, nil
+	}
+	implements := func(t reflect.Type) bool {
+		return t.Implements(textMarshalerType) || t.Implements(binaryMarshalerType)
+	}
+	var m interface{}
+	if implements(v.Type()) && v.CanInterface() {
+		m = v.Interface()
+	} else if v.CanAddr() && v.Addr().CanInterface() && implements(v.Addr().Type()) {
+		m = v.Addr().Interface()
+	}
+	switch m := m.(type) {
+	case encoding.TextMarshaler:
+		data, err = m.MarshalText()
+		return data, true, err
+	case encoding.BinaryMarshaler:
+		data, err = m.MarshalBinary()
+		return data, true, err
+	}
+	return nil, false, nil
+}
+
+func writeBytes(buf io.Writer, data []byte) error {
+	_, err := buf.Write([]byte(strconv.Itoa(len(data)) + ":"))
+	if err != nil {
+		return err
+	}
+	_, err = buf.Write(data)
+	return err
+}
+
 func encode(buf io.Writer, v reflect.Value) error {
+	if m := getMarshaler(v); m != nil {
+		data, err := m.MarshalBencode()
+		if err != nil {
+			return err
+		}
+		_, err = buf.Write(data)
+		return err
+	}
+	data, ok, err := marshalString(v)
+	if ok {
+		if err != nil {
+			return err
+		}
+		return writeBytes(buf, data)
+	}
 	switch v.Kind() {
 	case reflect.Int,
 		reflect.Int8, reflect.Int16,
@@ -86,6 +187,13 @@ func encode(buf io.Writer, v reflect.Value) error {
 		reflect.Uint32, reflect.Uint64:
 		_, err := buf.Write([]byte("i" + uint64Str(v.Uint()) + "e"))
 		return err
+	case reflect.Bool:
+		if v.Bool() {
+			_, err := buf.Write([]byte("i1e"))
+			return err
+		}
+		_, err := buf.Write([]byte("i0e"))
+		return err
 	case reflect.String:
 		_, err := buf.Write([]byte(uint64Str(uint64(v.Len())) + ":"))
 		if err != nil {
@@ -94,6 +202,9 @@ func encode(buf io.Writer, v reflect.Value) error {
 		_, err = buf.Write([]byte(v.String()))
 		return err
 	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return writeBytes(buf, v.Bytes())
+		}
 		_, err := buf.Write([]byte("l"))
 		if err != nil {
 			return err
@@ -107,26 +218,12 @@ func encode(buf io.Writer, v reflect.Value) error {
 		_, err = buf.Write([]byte("e"))
 		return err
 	case reflect.Array:
-		// if v.Type().ConvertibleTo(bytesType) {
-		// 	data := v.Bytes()
-		// 	_, err := buf.Write([]byte(fmt.Sprintf("%d:", len(data))))
-		// 	if err != nil {
-		// 		return err
-		// 	}
-		// 	_, err = buf.Write(data)
-		// 	return err
-		// }
-		if v.Index(0).Kind() == reflect.Uint8 {
+		if v.Type().Elem().Kind() == reflect.Uint8 {
 			data := make([]byte, v.Len())
-			for i := 0; i < v.Len(); i++ {
+			for i := range data {
 				data[i] = byte(v.Index(i).Uint())
 			}
-			_, err := buf.Write([]byte(fmt.Sprintf("%d:", v.Len())))
-			if err != nil {
-				return nil
-			}
-			_, err = buf.Write(data)
-			return err
+			return writeBytes(buf, data)
 		}
 		_, err := buf.Write([]byte("l"))
 		if err != nil {
@@ -143,17 +240,20 @@ func encode(buf io.Writer, v reflect.Value) error {
 	case reflect.Interface:
 		return encode(buf, v.Elem())
 	case reflect.Map:
-		_, err := buf.Write([]byte("d"))
+		keys, err := sortedMapKeys(v)
+		if err != nil {
+			return err
+		}
+		_, err = buf.Write([]byte("d"))
 		if err != nil {
 			return err
 		}
-		it := v.MapRange()
-		for it.Next() {
-			err = encode(buf, it.Key())
+		for _, k := range keys {
+			_, err = buf.Write([]byte(strconv.Itoa(len(k.key)) + ":" + k.key))
 			if err != nil {
 				return err
 			}
-			err = encode(buf, it.Value())
+			err = encode(buf, v.MapIndex(k.value))
 			if err != nil {
 				return err
 			}
@@ -165,36 +265,27 @@ func encode(buf io.Writer, v reflect.Value) error {
 	case reflect.Ptr:
 		return encode(buf, v.Elem())
 	case reflect.Struct:
-		t := v.Type()
+		if v.Type() == bigIntType {
+			n := v.Interface().(big.Int)
+			_, err := buf.Write([]byte("i" + n.String() + "e"))
+			return err
+		}
 		_, err := buf.Write([]byte("d"))
 		if err != nil {
 			return err
 		}
-		for i := 0; i < t.NumField(); i++ {
-			kField := t.Field(i)
-			vField := v.Field(i)
-			if kField.Anonymous { // inherit struct
-				var data bytes.Buffer
-				err = encode(&data, vField)
-				if err != nil {
-					return err
-				}
-				bt := data.Bytes()
-				if bt[0] == 'd' && bt[len(bt)-1] == 'e' {
-					buf.Write(bt[1 : len(bt)-1])
-				}
+		// BEP 3: keys must be strings and appear in sorted order,
+		// the cached fields are already sorted
+		for _, field := range cachedFields(v.Type()).fields {
+			fv, ok := fieldByIndex(v, field.index)
+			if !ok || (field.omitEmpty && isEmptyValue(fv)) {
 				continue
 			}
-			k := strings.ToLower(kField.Name)
-			tag := kField.Tag.Get("bencode")
-			if len(tag) > 0 {
-				k = tag
-			}
-			_, err = buf.Write([]byte(fmt.Sprintf("%d:%s", len(k), k)))
+			_, err = buf.Write([]byte(field.encKey))
 			if err != nil {
 				return err
 			}
-			err = encode(buf, vField)
+			err = encode(buf, fv)
 			if err != nil {
 				return err
 			}
@@ -203,8 +294,47 @@ func encode(buf io.Writer, v reflect.Value) error {
 		if err != nil {
 			return err
 		}
+	case reflect.Invalid:
+		return errors.New("not supported nil value")
 	default:
-		return fmt.Errorf("not supported %s value", v.Kind())
+		return &UnsupportedTypeError{Type: v.Type()}
 	}
 	return nil
 }
+
+type dictKey struct {
+	key   string
+	value reflect.Value
+}
+
+// sortedMapKeys returns the keys of map v sorted by their dict keys, the
+// map key must be string kind or implement encoding.TextMarshaler or
+// encoding.BinaryMarshaler
+func sortedMapKeys(v reflect.Value) ([]dictKey, error) {
+	t := v.Type().Key()
+	if t.Kind() != reflect.String &&
+		!t.Implements(textMarshalerType) && !t.Implements(binaryMarshalerType) {
+		return nil, &UnsupportedTypeError{Type: v.Type()}
+	}
+	keys := make([]dictKey, 0, v.Len())
+	for _, k := range v.MapKeys() {
+		key := dictKey{value: k}
+		if t.Kind() == reflect.String {
+			key.key = k.String()
+		} else {
+			data, ok, err := marshalString(k)
+			if !ok {
+				return nil, errors.New("not supported nil map key")
+			}
+			if err != nil {
+				return nil, err
+			}
+			key.key = string(data)
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].key < keys[j].key
+	})
+	return keys, nil
+}
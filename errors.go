@@ -0,0 +1,61 @@
+package bencode
+
+import (
+	"reflect"
+	"strconv"
+)
+
+// SyntaxError is a description of bencode syntax error
+type SyntaxError struct {
+	msg    string
+	Offset int64 // error occurred after reading Offset bytes
+}
+
+// NewSyntaxError returns a SyntaxError of msg at offset, it is used by
+// the code generated by bencodegen
+func NewSyntaxError(msg string, offset int64) *SyntaxError {
+	return &SyntaxError{msg: msg, Offset: offset}
+}
+
+func (e *SyntaxError) Error() string {
+	return e.msg + " at offset " + strconv.FormatInt(e.Offset, 10)
+}
+
+// UnmarshalTypeError describes a bencode value that was not appropriate
+// for a value of a specific Go type
+type UnmarshalTypeError struct {
+	Value  string       // description of bencode value - "number 1", "string", "list", "dict"
+	Type   reflect.Type // type of Go value it could not be assigned to
+	Offset int64        // error occurred after reading Offset bytes
+	Path   string       // path of the value, like info.files[3].length
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	msg := "can not set " + e.Value + " value to variable of type " + e.Type.String()
+	if len(e.Path) > 0 {
+		msg += " at path " + e.Path
+	}
+	return msg + " at offset " + strconv.FormatInt(e.Offset, 10)
+}
+
+// UnsupportedTypeError is returned by Encode when attempting
+// to encode an unsupported value type
+type UnsupportedTypeError struct {
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return "not supported " + e.Type.String() + " value"
+}
+
+// LimitError is returned by Decoder when the input exceeds one of its limits
+type LimitError struct {
+	Limit  string // "depth", "string length", "total bytes" or "element count"
+	Max    int64
+	Offset int64 // error occurred after reading Offset bytes
+}
+
+func (e *LimitError) Error() string {
+	return "exceeded max " + e.Limit + " " + strconv.FormatInt(e.Max, 10) +
+		" at offset " + strconv.FormatInt(e.Offset, 10)
+}
@@ -0,0 +1,156 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSyntaxError(t *testing.T) {
+	cases := map[string]int64{
+		"i12":           3,
+		"i1x2e":         5,
+		"4:abc":         5,
+		"d1:ai1e1:ai2e": 10,
+		"li1e":          4,
+		"d1:a":          4,
+	}
+	for str, offset := range cases {
+		var intf interface{}
+		err := Decode([]byte(str), &intf)
+		var e *SyntaxError
+		if !errors.As(err, &e) {
+			t.Fatalf("unexpected error of %s: %v", str, err)
+		}
+		if e.Offset != offset {
+			t.Fatalf("unexpected offset of %s: %d", str, e.Offset)
+		}
+	}
+}
+
+func TestTrailingDataError(t *testing.T) {
+	src := []byte("i1egarbage")
+	var buf bytes.Buffer
+	_, jsonErr := ToJSON(src, BytesWrapper)
+	for name, err := range map[string]error{
+		"Canonicalize": Canonicalize(&buf, src),
+		"Dump":         Dump(&buf, src, DumpOptions{}),
+		"ToJSON":       jsonErr,
+	} {
+		var e *SyntaxError
+		if !errors.As(err, &e) {
+			t.Fatalf("unexpected error of %s: %v", name, err)
+		}
+		if e.Offset != 3 {
+			t.Fatalf("unexpected offset of %s: %d", name, e.Offset)
+		}
+	}
+}
+
+func TestUnmarshalTypeError(t *testing.T) {
+	type file struct {
+		Length int64    `bencode:"length"`
+		Path   []string `bencode:"path"`
+	}
+	var torrent struct {
+		Info struct {
+			Files []file `bencode:"files"`
+		} `bencode:"info"`
+	}
+	str := []byte("d4:infod5:filesld6:lengthi1eed6:lengthi2eed6:length1:3eeee")
+	err := Decode(str, &torrent)
+	var e *UnmarshalTypeError
+	if !errors.As(err, &e) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if e.Value != "string" {
+		t.Fatalf("unexpected value: %s", e.Value)
+	}
+	if e.Type != reflect.TypeOf(int64(0)) {
+		t.Fatalf("unexpected type: %s", e.Type.String())
+	}
+	if e.Path != "info.files[2].length" {
+		t.Fatalf("unexpected path: %s", e.Path)
+	}
+	if e.Offset != 54 {
+		t.Fatalf("unexpected offset: %d", e.Offset)
+	}
+
+	var n int8
+	err = Decode([]byte("i128e"), &n)
+	if !errors.As(err, &e) {
+		t.Fatalf("unexpected overflow error: %v", err)
+	}
+	if e.Value != "number 128" {
+		t.Fatalf("unexpected overflow value: %s", e.Value)
+	}
+}
+
+func TestUnsupportedTypeError(t *testing.T) {
+	_, err := Encode(map[string]interface{}{"a": 1.5})
+	var e *UnsupportedTypeError
+	if !errors.As(err, &e) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if e.Type != reflect.TypeOf(1.5) {
+		t.Fatalf("unexpected type: %s", e.Type.String())
+	}
+}
+
+func TestLimitError(t *testing.T) {
+	run := func(str string, limit string, set func(dec Decoder)) {
+		dec := NewDecoder(bytes.NewReader([]byte(str)))
+		set(dec)
+		var intf interface{}
+		err := dec.Decode(&intf)
+		var e *LimitError
+		if !errors.As(err, &e) {
+			t.Fatalf("unexpected error of %s: %v", str, err)
+		}
+		if e.Limit != limit {
+			t.Fatalf("unexpected limit of %s: %s", str, e.Limit)
+		}
+	}
+	run("lllleeee", "depth", func(dec Decoder) {
+		dec.SetMaxDepth(3)
+	})
+	run("d1:ad1:bd1:ci1eeee", "depth", func(dec Decoder) {
+		dec.SetMaxDepth(2)
+	})
+	run("99999999999:", "string length", func(dec Decoder) {
+		dec.SetMaxStringLength(1024)
+	})
+	run("99999999999:", "total bytes", func(dec Decoder) {
+		dec.SetMaxTotalBytes(1024)
+	})
+	run("li1ei2ei3ee", "total bytes", func(dec Decoder) {
+		dec.SetMaxTotalBytes(8)
+	})
+	run("li1ei2ei3ee", "element count", func(dec Decoder) {
+		dec.SetMaxElements(2)
+	})
+	run("d1:ai1e1:bi2ee", "element count", func(dec Decoder) {
+		dec.SetMaxElements(1)
+	})
+
+	dec := NewDecoder(bytes.NewReader([]byte("d1:ali1ei2eee")))
+	dec.SetMaxDepth(2)
+	dec.SetMaxStringLength(1)
+	dec.SetMaxTotalBytes(13)
+	dec.SetMaxElements(3)
+	var intf interface{}
+	err := dec.Decode(&intf)
+	if err != nil {
+		t.Fatalf("FATAL: decode in limits: %v", err)
+	}
+}
+
+func TestForgedStringSize(t *testing.T) {
+	var str string
+	err := Decode([]byte("99999999999:abc"), &str)
+	var e *SyntaxError
+	if !errors.As(err, &e) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
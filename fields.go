@@ -0,0 +1,172 @@
+package bencode
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// field is the cached metadata of struct field which is encoded as
+// dict key, the fields of embedded structs are flattened
+type field struct {
+	key       string
+	encKey    string // encoded key with its size
+	index     []int
+	tagged    bool // the key is named by tag
+	omitEmpty bool
+}
+
+// structInfo is the cached field table of struct type, shared by
+// encoder and decoder
+type structInfo struct {
+	fields []field          // sorted by key for encoding
+	byKey  map[string][]int // index sequence of field by dict key
+}
+
+var fieldCache sync.Map // map[reflect.Type]*structInfo
+
+// cachedFields returns the field table of struct type t
+func cachedFields(t reflect.Type) *structInfo {
+	if info, ok := fieldCache.Load(t); ok {
+		return info.(*structInfo)
+	}
+	info, _ := fieldCache.LoadOrStore(t, typeFields(t))
+	return info.(*structInfo)
+}
+
+func typeFields(t reflect.Type) *structInfo {
+	info := &structInfo{
+		fields: dominantFields(t),
+		byKey:  make(map[string][]int),
+	}
+	for _, f := range info.fields {
+		info.byKey[f.key] = f.index
+	}
+	return info
+}
+
+// dominantFields returns the encoded fields of t sorted by key, the
+// fields of embedded structs are flattened in the same way as Go
+// selectors, a shallower field hides the deeper ones, at the same
+// depth a tagged field hides the untagged ones, and the key is
+// dropped when it is still ambiguous
+func dominantFields(t reflect.Type) []field {
+	type embedded struct {
+		typ   reflect.Type
+		index []int
+	}
+	var fields []field
+	next := []embedded{{typ: t}}
+	var count, nextCount map[reflect.Type]int
+	// the struct embedded at a shallower depth is walked only once, its
+	// deeper fields are always hidden, and it stops self embedding
+	visited := make(map[reflect.Type]bool)
+	for len(next) > 0 {
+		current := next
+		next = nil
+		count, nextCount = nextCount, make(map[reflect.Type]int)
+		for _, e := range current {
+			if visited[e.typ] {
+				continue
+			}
+			visited[e.typ] = true
+			for i := 0; i < e.typ.NumField(); i++ {
+				kField := e.typ.Field(i)
+				index := make([]int, len(e.index)+1)
+				copy(index, e.index)
+				index[len(e.index)] = i
+				if isEmbedded(kField) { // inherit struct
+					ft := indirectType(kField.Type)
+					nextCount[ft]++
+					if nextCount[ft] == 1 {
+						next = append(next, embedded{typ: ft, index: index})
+					}
+					continue
+				}
+				if !isVisible(kField) {
+					continue
+				}
+				k, opts, skip := fieldKey(kField)
+				if skip {
+					continue
+				}
+				tagName, _ := parseTag(kField.Tag.Get("bencode"))
+				f := field{
+					key:       k,
+					encKey:    strconv.Itoa(len(k)) + ":" + k,
+					index:     index,
+					tagged:    len(tagName) > 0,
+					omitEmpty: opts.Contains("omitempty"),
+				}
+				fields = append(fields, f)
+				if count[e.typ] > 1 {
+					// the same struct is embedded more than once at
+					// this depth, so its fields are ambiguous
+					fields = append(fields, f)
+				}
+			}
+		}
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		a, b := fields[i], fields[j]
+		if a.key != b.key {
+			return a.key < b.key
+		}
+		if len(a.index) != len(b.index) {
+			return len(a.index) < len(b.index)
+		}
+		if a.tagged != b.tagged {
+			return a.tagged
+		}
+		return indexLess(a.index, b.index)
+	})
+	ret := fields[:0]
+	for i := 0; i < len(fields); {
+		j := i + 1
+		for j < len(fields) && fields[j].key == fields[i].key {
+			j++
+		}
+		group := fields[i:j]
+		if len(group) == 1 || len(group[0].index) < len(group[1].index) ||
+			group[0].tagged != group[1].tagged {
+			ret = append(ret, group[0])
+		}
+		i = j
+	}
+	return ret
+}
+
+func indexLess(a, b []int) bool {
+	for i := range a {
+		if i >= len(b) {
+			return false
+		}
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+	return t
+}
+
+// fieldByIndex returns the field of v by index sequence, ok is false
+// when an embedded pointer in the way is nil
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, idx := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(idx)
+	}
+	return v, true
+}
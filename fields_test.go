@@ -0,0 +1,89 @@
+package bencode
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestCachedFields(t *testing.T) {
+	type inner struct {
+		A int
+		C int `bencode:"c"`
+	}
+	type outer struct {
+		*inner
+		B int `bencode:"b,omitempty"`
+		C int `bencode:"a"`
+	}
+	info := cachedFields(reflect.TypeOf(outer{}))
+	if info != cachedFields(reflect.TypeOf(outer{})) {
+		t.Fatalf("field table is not cached")
+	}
+	var keys []string
+	var index [][]int
+	for _, field := range info.fields {
+		keys = append(keys, field.key)
+		index = append(index, field.index)
+	}
+	if !reflect.DeepEqual(keys, []string{"a", "b", "c"}) ||
+		!reflect.DeepEqual(index, [][]int{{2}, {1}, {0, 1}}) {
+		t.Fatalf("unexpected keys: %v %v", keys, index)
+	}
+	if !reflect.DeepEqual(info.byKey["a"], []int{2}) ||
+		!reflect.DeepEqual(info.byKey["c"], []int{0, 1}) ||
+		!reflect.DeepEqual(info.byKey["b"], []int{1}) {
+		t.Fatalf("unexpected index: %v", info.byKey)
+	}
+
+	// nil embedded pointer is skipped
+	data, err := Encode(outer{C: 1})
+	if err != nil {
+		t.Fatalf("FATAL: encode: %v", err)
+	}
+	if string(data) != "d1:ai1ee" {
+		t.Fatalf("unexpected encoded: %s", data)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var v outer
+			err := Decode([]byte("d1:ai1e1:bi2ee"), &v)
+			if err != nil || v.C != 1 || v.B != 2 {
+				t.Errorf("unexpected decoded: %v %+v", err, v)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// node embeds itself, its field table must be finite
+type node struct {
+	*node
+	V int
+}
+
+func TestCachedFieldsRecursive(t *testing.T) {
+	info := cachedFields(reflect.TypeOf(node{}))
+	if len(info.fields) != 1 || !reflect.DeepEqual(info.byKey["v"], []int{1}) {
+		t.Fatalf("unexpected fields: %+v", info.fields)
+	}
+	var n node
+	err := Decode([]byte("d1:ai2e1:vi1ee"), &n)
+	if err != nil {
+		t.Fatalf("FATAL: decode recursive: %v", err)
+	}
+	if n.V != 1 || n.node != nil {
+		t.Fatalf("unexpected decoded: %+v", n)
+	}
+	data, err := Encode(node{node: &node{V: 2}, V: 1})
+	if err != nil {
+		t.Fatalf("FATAL: encode recursive: %v", err)
+	}
+	if string(data) != "d1:vi1ee" {
+		t.Fatalf("unexpected encoded: %s", data)
+	}
+}
@@ -0,0 +1,308 @@
+package bencode
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"unicode/utf8"
+)
+
+// BytesEncoding is the representation of binary strings in JSON
+type BytesEncoding int
+
+const (
+	// BytesWrapper represents binary strings as {"$bytes": "<base64>"},
+	// binary dict keys are represented as "$bytes:<base64>"
+	BytesWrapper BytesEncoding = iota
+	// BytesHex represents binary strings as "hex:<hex>"
+	BytesHex
+	// BytesBase64 represents binary strings as "base64:<base64>"
+	BytesBase64
+)
+
+const (
+	jsonBytesKey  = "$bytes"
+	jsonDictKey   = "$dict"
+	jsonHexPrefix = "hex:"
+	jsonB64Prefix = "base64:"
+)
+
+// ToJSON converts bencode data into JSON, numbers are kept in any
+// precision, the order of dict keys is kept and the strings which are
+// not valid UTF-8 are represented by enc, so FromJSON with the same enc
+// converts it back into the same bytes
+func ToJSON(data []byte, enc BytesEncoding) ([]byte, error) {
+	var v Value
+	dec := NewBytesDecoder(data)
+	dec.AllowNonCanonical()
+	err := dec.Decode(&v)
+	if err != nil {
+		return nil, err
+	}
+	err = dec.endError(len(data))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	err = writeJSON(&buf, v, enc)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// prefix returns the prefix of binary string
+func (enc BytesEncoding) prefix() string {
+	switch enc {
+	case BytesHex:
+		return jsonHexPrefix
+	case BytesBase64:
+		return jsonB64Prefix
+	default:
+		return jsonBytesKey + ":"
+	}
+}
+
+func (enc BytesEncoding) encode(data []byte) string {
+	if enc == BytesHex {
+		return hex.EncodeToString(data)
+	}
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+func (enc BytesEncoding) decode(str string) ([]byte, error) {
+	if enc == BytesHex {
+		return hex.DecodeString(str)
+	}
+	return base64.StdEncoding.DecodeString(str)
+}
+
+// jsonString returns the JSON string of data, isKey is true for dict keys
+func jsonString(data []byte, enc BytesEncoding, isKey bool) (string, bool) {
+	if enc == BytesWrapper && !isKey {
+		if !utf8.Valid(data) {
+			return "", false
+		}
+		return string(data), true
+	}
+	prefix := enc.prefix()
+	if !utf8.Valid(data) || strings.HasPrefix(string(data), prefix) {
+		// strings which look like encoded are also encoded
+		return prefix + enc.encode(data), true
+	}
+	return string(data), true
+}
+
+func writeJSONString(buf *bytes.Buffer, str string) error {
+	data, err := json.Marshal(str)
+	if err != nil {
+		return err
+	}
+	buf.Write(data)
+	return nil
+}
+
+func writeJSON(buf *bytes.Buffer, v Value, enc BytesEncoding) error {
+	switch v.Kind() {
+	case IntKind:
+		buf.WriteString(v.num)
+	case StringKind:
+		str, ok := jsonString(v.Bytes(), enc, false)
+		if !ok {
+			buf.WriteString(`{"` + jsonBytesKey + `":"` + enc.encode(v.Bytes()) + `"}`)
+			return nil
+		}
+		return writeJSONString(buf, str)
+	case ListKind:
+		buf.WriteByte('[')
+		for i, item := range v.List() {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			err := writeJSON(buf, item, enc)
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case DictKind:
+		entries := v.Entries()
+		wrapped := enc == BytesWrapper && len(entries) == 1 &&
+			(entries[0].Key == jsonBytesKey || entries[0].Key == jsonDictKey)
+		if wrapped {
+			// escape the dict which looks like a wrapper
+			buf.WriteString(`{"` + jsonDictKey + `":`)
+		}
+		buf.WriteByte('{')
+		for i, entry := range entries {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			key, _ := jsonString([]byte(entry.Key), enc, true)
+			err := writeJSONString(buf, key)
+			if err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			err = writeJSON(buf, entry.Value, enc)
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+		if wrapped {
+			buf.WriteByte('}')
+		}
+	default:
+		return errors.New("encode invalid value")
+	}
+	return nil
+}
+
+// FromJSON converts JSON produced by ToJSON with the same enc back into
+// bencode, the order of object keys is kept, only integer numbers,
+// strings, arrays and objects are supported, and duplicate object keys
+// are rejected
+func FromJSON(data []byte, enc BytesEncoding) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	tk, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	v, err := readJSON(dec, tk, enc)
+	if err != nil {
+		return nil, err
+	}
+	if enc == BytesWrapper {
+		v, err = unwrapJSON(v, enc)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, err = dec.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after JSON value")
+	}
+	return v.MarshalBencode()
+}
+
+func fromJSONString(str string, enc BytesEncoding, isKey bool) (string, error) {
+	if enc == BytesWrapper && !isKey {
+		return str, nil
+	}
+	prefix := enc.prefix()
+	if !strings.HasPrefix(str, prefix) {
+		return str, nil
+	}
+	data, err := enc.decode(str[len(prefix):])
+	if err != nil {
+		return "", fmt.Errorf("invalid encoded string %q: %v", str, err)
+	}
+	return string(data), nil
+}
+
+func readJSON(dec *json.Decoder, tk json.Token, enc BytesEncoding) (Value, error) {
+	switch tk := tk.(type) {
+	case json.Number:
+		n, ok := new(big.Int).SetString(tk.String(), 10)
+		if !ok {
+			return Value{}, fmt.Errorf("not supported number %s", tk.String())
+		}
+		return NewBigInt(n), nil
+	case string:
+		str, err := fromJSONString(tk, enc, false)
+		if err != nil {
+			return Value{}, err
+		}
+		return NewString(str), nil
+	case json.Delim:
+		if tk == '[' {
+			list := NewList()
+			for dec.More() {
+				next, err := dec.Token()
+				if err != nil {
+					return Value{}, err
+				}
+				item, err := readJSON(dec, next, enc)
+				if err != nil {
+					return Value{}, err
+				}
+				list.Append(item)
+			}
+			_, err := dec.Token()
+			return list, err
+		}
+		dict := NewDict()
+		keys := make(map[string]bool)
+		for dec.More() {
+			next, err := dec.Token()
+			if err != nil {
+				return Value{}, err
+			}
+			key, err := fromJSONString(next.(string), enc, true)
+			if err != nil {
+				return Value{}, err
+			}
+			if keys[key] {
+				return Value{}, fmt.Errorf("duplicate JSON object key %q", key)
+			}
+			keys[key] = true
+			next, err = dec.Token()
+			if err != nil {
+				return Value{}, err
+			}
+			item, err := readJSON(dec, next, enc)
+			if err != nil {
+				return Value{}, err
+			}
+			dict.dict = append(dict.dict, DictEntry{Key: key, Value: item})
+		}
+		_, err := dec.Token()
+		return dict, err
+	}
+	return Value{}, fmt.Errorf("not supported JSON value %v", tk)
+}
+
+// unwrapJSON converts the wrapper objects of BytesWrapper into their values
+func unwrapJSON(v Value, enc BytesEncoding) (Value, error) {
+	switch v.Kind() {
+	case ListKind:
+		for i, item := range v.list {
+			item, err := unwrapJSON(item, enc)
+			if err != nil {
+				return Value{}, err
+			}
+			v.list[i] = item
+		}
+		return v, nil
+	case DictKind:
+		if len(v.dict) == 1 {
+			entry := v.dict[0]
+			switch {
+			case entry.Key == jsonBytesKey && entry.Value.Kind() == StringKind:
+				data, err := enc.decode(entry.Value.String())
+				if err != nil {
+					return Value{}, fmt.Errorf("invalid %s value: %v", jsonBytesKey, err)
+				}
+				return NewBytes(data), nil
+			case entry.Key == jsonDictKey && entry.Value.Kind() == DictKind:
+				// escaped dict, only its values are unwrapped
+				v = entry.Value
+			}
+		}
+		for i, entry := range v.dict {
+			item, err := unwrapJSON(entry.Value, enc)
+			if err != nil {
+				return Value{}, err
+			}
+			v.dict[i].Value = item
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,54 @@
+package bencode
+
+import (
+	"testing"
+)
+
+func TestJSON(t *testing.T) {
+	run := func(enc BytesEncoding, str, want string) {
+		data, err := ToJSON([]byte(str), enc)
+		if err != nil {
+			t.Fatalf("FATAL: to json %q: %v", str, err)
+		}
+		if string(data) != want {
+			t.Fatalf("unexpected json of %q: %s", str, data)
+		}
+		back, err := FromJSON(data, enc)
+		if err != nil {
+			t.Fatalf("FATAL: from json %s: %v", data, err)
+		}
+		if string(back) != str {
+			t.Fatalf("unexpected bencode of %s: %q", data, back)
+		}
+	}
+	run(BytesWrapper, "i123456789012345678901234567890e", "123456789012345678901234567890")
+	run(BytesWrapper, "d1:bi1e1:ai2ee", `{"b":1,"a":2}`)
+	run(BytesWrapper, "d6:pieces2:\xff\x00e", `{"pieces":{"$bytes":"/wA="}}`)
+	run(BytesWrapper, "l6:$bytes8:hex:abcde", `["$bytes","hex:abcd"]`)
+	run(BytesWrapper, "d2:\xff\xffi1ee", `{"$bytes://8=":1}`)
+	run(BytesWrapper, "d6:$bytes3:abce", `{"$dict":{"$bytes":"abc"}}`)
+	run(BytesWrapper, "d5:$dictd1:xi1eee", `{"$dict":{"$dict":{"x":1}}}`)
+	run(BytesWrapper, "d5:$dictd5:$dictd1:xi1eeee", `{"$dict":{"$dict":{"$dict":{"$dict":{"x":1}}}}}`)
+	run(BytesWrapper, "d5:$dictd1:xi1ee1:ai1ee", `{"$dict":{"x":1},"a":1}`)
+	run(BytesHex, "l2:\xff\x006:hex:ab3:abce", `["hex:ff00","hex:6865783a6162","abc"]`)
+	run(BytesHex, "d6:$bytes2:\xff\x00e", `{"$bytes":"hex:ff00"}`)
+	run(BytesBase64, "d2:\xff\xff7:base64:e", `{"base64://8=":"base64:YmFzZTY0Og=="}`)
+
+	for _, str := range []string{`1.5`, `true`, `null`, `[1] 2`, `{"$bytes":"!"}`,
+		`{"b":1,"a":2,"a":3}`, `{"$bytes:YQ==":1,"a":2}`} {
+		_, err := FromJSON([]byte(str), BytesWrapper)
+		if err == nil {
+			t.Fatalf("expected error of %s", str)
+		}
+	}
+	_, err := FromJSON([]byte(`"hex:zz"`), BytesHex)
+	if err == nil {
+		t.Fatalf("expected error of invalid hex")
+	}
+	for _, str := range []string{"i1egarbage", "i1ei2e", "l"} {
+		_, err = ToJSON([]byte(str), BytesWrapper)
+		if err == nil {
+			t.Fatalf("expected error of %q", str)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package bencode
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Query is a compiled path expression over bencode data, the syntax is:
+//
+//	info.name            value of key name in dict info
+//	info.files[0]        first element of list files
+//	info.files[*].path   key path of every element in list files
+//	info.*               every value in dict info
+//	["piece length"]     quoted key, for keys contain '.', '[' or ']'
+//
+// an empty expression matches the whole value.
+type Query struct {
+	expr  string
+	steps []queryStep
+}
+
+type queryStep struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// Match is a value matched by Query
+type Match struct {
+	Path   string     // path of the value, like info.files[3].length
+	Offset int64      // offset of the value in input data
+	Raw    RawMessage // raw bytes of the value, it references the input data
+}
+
+// Value decode the raw bytes of the match, it accepts non-canonical
+// encodings as Find does
+func (m Match) Value() (Value, error) {
+	var v Value
+	dec := NewBytesDecoder(m.Raw)
+	dec.AllowNonCanonical()
+	err := dec.Decode(&v)
+	return v, err
+}
+
+// CompileQuery parses a path expression
+func CompileQuery(expr string) (*Query, error) {
+	q := &Query{expr: expr}
+	i := 0
+	for i < len(expr) {
+		switch {
+		case expr[i] == '[':
+			end := strings.IndexByte(expr[i:], ']')
+			if end == -1 {
+				return nil, fmt.Errorf("missing ] in query %q", expr)
+			}
+			inner := expr[i+1 : i+end]
+			if strings.HasPrefix(inner, "\"") {
+				key, n, err := unquoteKey(expr[i+1:])
+				if err != nil {
+					return nil, fmt.Errorf("invalid quoted key in query %q: %v", expr, err)
+				}
+				if i+1+n >= len(expr) || expr[i+1+n] != ']' {
+					return nil, fmt.Errorf("missing ] in query %q", expr)
+				}
+				q.steps = append(q.steps, queryStep{key: key})
+				i += n + 2
+				continue
+			}
+			if inner == "*" {
+				q.steps = append(q.steps, queryStep{wildcard: true})
+			} else {
+				n, err := strconv.Atoi(inner)
+				if err != nil || n < 0 {
+					return nil, fmt.Errorf("invalid index %q in query %q", inner, expr)
+				}
+				q.steps = append(q.steps, queryStep{index: n, isIndex: true})
+			}
+			i += end + 1
+		default:
+			if expr[i] == '.' {
+				if i == 0 {
+					return nil, fmt.Errorf("unexpected . at the beginning of query %q", expr)
+				}
+				i++
+			}
+			end := strings.IndexAny(expr[i:], ".[")
+			if end == -1 {
+				end = len(expr) - i
+			}
+			key := expr[i : i+end]
+			if len(key) == 0 {
+				return nil, fmt.Errorf("empty key in query %q", expr)
+			}
+			if strings.IndexByte(key, ']') != -1 {
+				return nil, fmt.Errorf("unexpected ] in query %q", expr)
+			}
+			if key == "*" {
+				q.steps = append(q.steps, queryStep{wildcard: true})
+			} else {
+				q.steps = append(q.steps, queryStep{key: key})
+			}
+			i += end
+		}
+	}
+	return q, nil
+}
+
+// MustCompileQuery is like CompileQuery but panics on error
+func MustCompileQuery(expr string) *Query {
+	q, err := CompileQuery(expr)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+// unquoteKey unquote the string at the beginning of str,
+// it returns the key and the length of quoted string
+func unquoteKey(str string) (string, int, error) {
+	for i := 1; i < len(str); i++ {
+		switch str[i] {
+		case '\\':
+			i++
+		case '"':
+			key, err := strconv.Unquote(str[:i+1])
+			return key, i + 1, err
+		}
+	}
+	return "", 0, errors.New("missing closing quote")
+}
+
+func (q *Query) String() string {
+	return q.expr
+}
+
+// Find returns all values matched by q in data
+func (q *Query) Find(data []byte) ([]Match, error) {
+	dec := NewBytesDecoder(data)
+	dec.AllowNonCanonical()
+	var ret []Match
+	err := q.match(dec, data, q.steps, "", &ret)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// Find returns all values matched by path expression in data
+func Find(data []byte, expr string) ([]Match, error) {
+	q, err := CompileQuery(expr)
+	if err != nil {
+		return nil, err
+	}
+	return q.Find(data)
+}
+
+func (q *Query) match(dec Decoder, data []byte, steps []queryStep, path string, ret *[]Match) error {
+	if len(steps) == 0 {
+		start := dec.InputOffset()
+		err := dec.Skip()
+		if err != nil {
+			return err
+		}
+		*ret = append(*ret, Match{
+			Path:   strings.TrimPrefix(path, "."),
+			Offset: start,
+			Raw:    data[start:dec.InputOffset()],
+		})
+		return nil
+	}
+	tk, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tk != DictStart && tk != ListStart {
+		// number or string has no children
+		return nil
+	}
+	step := steps[0]
+	for i := 0; dec.More(); i++ {
+		next := path
+		matched := false
+		if tk == DictStart {
+			key, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			k := string(key.(String))
+			next += queryKey(k)
+			matched = step.wildcard || (!step.isIndex && step.key == k)
+		} else {
+			next += "[" + strconv.Itoa(i) + "]"
+			matched = step.wildcard || (step.isIndex && step.index == i)
+		}
+		if matched {
+			err = q.match(dec, data, steps[1:], next, ret)
+		} else {
+			err = dec.Skip()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err = dec.Token()
+	return err
+}
+
+// queryKey returns the path segment of key
+func queryKey(key string) string {
+	if len(key) == 0 || key == "*" || strings.ContainsAny(key, ".[]\"") {
+		return "[" + strconv.Quote(key) + "]"
+	}
+	return "." + key
+}
@@ -0,0 +1,66 @@
+package bencode
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	str := []byte("d4:infod5:filesld6:lengthi1e4:pathl1:a1:beed6:lengthi2e4:pathl1:ceee4:name3:abc12:piece lengthi16384ee1:xd3:a.bi1eee")
+	run := func(expr string, want ...string) {
+		matches, err := Find(str, expr)
+		if err != nil {
+			t.Fatalf("FATAL: query %s: %v", expr, err)
+		}
+		var got []string
+		for _, m := range matches {
+			if string(str[m.Offset:m.Offset+int64(len(m.Raw))]) != string(m.Raw) {
+				t.Fatalf("unexpected offset of %s: %d", m.Path, m.Offset)
+			}
+			got = append(got, m.Path+"="+string(m.Raw))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("unexpected result of %s: %v", expr, got)
+		}
+	}
+	run("info.name", "info.name=3:abc")
+	run("info.files[*].path",
+		"info.files[0].path=l1:a1:be",
+		"info.files[1].path=l1:ce")
+	run("info.files[1].length", "info.files[1].length=i2e")
+	run("info.files[*].path[0]",
+		"info.files[0].path[0]=1:a",
+		"info.files[1].path[0]=1:c")
+	run(`info["piece length"]`, "info.piece length=i16384e")
+	run(`x["a.b"]`, `x["a.b"]=i1e`)
+	run("x.*", `x["a.b"]=i1e`)
+	run("info.missing")
+	run("info.name.x")
+	run("info.files[2]")
+	run("info[0]")
+	run("", "="+string(str))
+
+	m, err := Find(str, "info.files[0]")
+	if err != nil {
+		t.Fatalf("FATAL: query info.files[0]: %v", err)
+	}
+	v, err := m[0].Value()
+	if err != nil {
+		t.Fatalf("FATAL: decode match value: %v", err)
+	}
+	if v.Get("length").Int() != 1 {
+		t.Fatalf("unexpected value of length: %d", v.Get("length").Int())
+	}
+
+	invalid := []string{".a", "a.", "a..b", "a[", "a[x]", "a[-1]", `a["b]`, `a["b"`, "a]"}
+	for _, expr := range invalid {
+		_, err := CompileQuery(expr)
+		if err == nil {
+			t.Fatalf("expected error on compile %s", expr)
+		}
+	}
+	_, err = Find([]byte("d4:infoli1e"), "info[*]")
+	if err == nil {
+		t.Fatal("expected error on truncated input")
+	}
+}
@@ -0,0 +1,24 @@
+package bencode
+
+import "errors"
+
+// RawMessage is a raw encoded bencode value, it can be used to
+// delay decoding or capture the exact bytes of a value
+type RawMessage []byte
+
+// MarshalBencode returns m as the bencode encoding of m
+func (m RawMessage) MarshalBencode() ([]byte, error) {
+	if len(m) == 0 {
+		return nil, errors.New("empty raw message")
+	}
+	return m, nil
+}
+
+// UnmarshalBencode sets *m to a copy of data
+func (m *RawMessage) UnmarshalBencode(data []byte) error {
+	if m == nil {
+		return errors.New("unmarshal on nil pointer of raw message")
+	}
+	*m = append((*m)[0:0], data...)
+	return nil
+}
@@ -0,0 +1,76 @@
+package bencode
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"testing"
+)
+
+func TestRawMessageInfoHash(t *testing.T) {
+	// unknown key x of info will be lost when re-encoded from struct
+	info := "d6:lengthi1e4:name1:a12:piece lengthi16384e6:pieces0:1:xi1ee"
+	str := []byte("d8:announce3:abc4:info" + info + "e")
+	var torrent struct {
+		Announce string     `bencode:"announce"`
+		Info     RawMessage `bencode:"info"`
+	}
+	err := Decode(str, &torrent)
+	if err != nil {
+		t.Fatalf("FATAL: decode raw message: %v", err)
+	}
+	if torrent.Announce != "abc" {
+		t.Fatalf("unexpected value of announce: %s", torrent.Announce)
+	}
+	if !bytes.Equal(torrent.Info, []byte(info)) {
+		t.Fatalf("unexpected value of info: %s", string(torrent.Info))
+	}
+	if sha1.Sum(torrent.Info) != sha1.Sum([]byte(info)) {
+		t.Fatal("unexpected info hash")
+	}
+	data, err := Encode(torrent)
+	if err != nil {
+		t.Fatalf("FATAL: encode raw message: %v", err)
+	}
+	if !bytes.Equal(data, str) {
+		t.Fatalf("unexpected encoded value: %s", string(data))
+	}
+}
+
+func TestRawMessageDeferred(t *testing.T) {
+	str := []byte("d1:ad2:id20:abcdefghij0123456789e1:q4:ping1:t2:aa1:y1:qe")
+	var envelope struct {
+		Transaction string     `bencode:"t"`
+		Type        string     `bencode:"y"`
+		Query       string     `bencode:"q"`
+		Args        RawMessage `bencode:"a"`
+	}
+	err := Decode(str, &envelope)
+	if err != nil {
+		t.Fatalf("FATAL: decode envelope: %v", err)
+	}
+	if envelope.Query != "ping" {
+		t.Fatalf("unexpected value of q: %s", envelope.Query)
+	}
+	var ping struct {
+		ID [20]byte `bencode:"id"`
+	}
+	err = Decode(envelope.Args, &ping)
+	if err != nil {
+		t.Fatalf("FATAL: decode ping args: %v", err)
+	}
+	if !bytes.Equal(ping.ID[:], []byte("abcdefghij0123456789")) {
+		t.Fatalf("unexpected value of a.id: %s", string(ping.ID[:]))
+	}
+	var raw RawMessage
+	err = Decode([]byte("li1eli2eee"), &raw)
+	if err != nil {
+		t.Fatalf("FATAL: decode raw list: %v", err)
+	}
+	if string(raw) != "li1eli2eee" {
+		t.Fatalf("unexpected raw list value: %s", string(raw))
+	}
+	_, err = Encode(RawMessage(nil))
+	if err == nil {
+		t.Fatal("expected error on encode empty raw message")
+	}
+}
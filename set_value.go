@@ -1,319 +1,203 @@
 package bencode
 
 import (
-	"errors"
-	"fmt"
-	"io"
+	"encoding"
+	"math/big"
 	"reflect"
-	"strings"
+	"strconv"
 )
 
 type notfound struct{}
 
 var notfoundType = reflect.TypeOf(notfound{})
+var bigIntType = reflect.TypeOf(big.Int{})
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+var binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
 
-func setNumber(n number, key string, v reflect.Value) error {
+// indirect allocates nil pointers and returns the value they point to
+func indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+func setNumber(n number, v reflect.Value) error {
 	if v.Type() == notfoundType {
 		return nil
 	}
-	newNumber := func() reflect.Value {
-		value := reflect.New(reflect.TypeOf(0))
-		value.Elem().SetInt(n.signed)
-		return value.Elem()
-	}
-	newMap := func() {
-		value := reflect.MakeMap(reflect.TypeOf(map[string]interface{}{}))
-		value.SetMapIndex(reflect.ValueOf(key), newNumber())
-		v.Set(value)
+	if v.Type() == bigIntType {
+		v.Set(reflect.ValueOf(*n.bigInt()))
+		return nil
 	}
 	switch v.Kind() {
 	case reflect.Int,
 		reflect.Int8, reflect.Int16,
 		reflect.Int32, reflect.Int64:
+		if n.big != nil || v.OverflowInt(n.signed) {
+			return &UnmarshalTypeError{Value: "number " + n.bigInt().String(), Type: v.Type()}
+		}
 		v.SetInt(n.signed)
 	case reflect.Uint,
 		reflect.Uint8, reflect.Uint16,
 		reflect.Uint32, reflect.Uint64:
+		if (n.big != nil && !n.big.IsUint64()) || (n.big == nil && n.signed < 0) ||
+			v.OverflowUint(n.unsigned) {
+			return &UnmarshalTypeError{Value: "number " + n.bigInt().String(), Type: v.Type()}
+		}
 		v.SetUint(n.unsigned)
+	case reflect.Bool:
+		if n.big != nil || (n.signed != 0 && n.signed != 1) {
+			return &UnmarshalTypeError{Value: "number " + n.bigInt().String(), Type: v.Type()}
+		}
+		v.SetBool(n.signed == 1)
 	case reflect.Interface:
-		if len(key) > 0 {
-			if v.IsNil() {
-				newMap()
-				return nil
-			}
-			return setNumber(n, key, v.Elem())
+		if v.NumMethod() > 0 {
+			return &UnmarshalTypeError{Value: "number", Type: v.Type()}
 		}
-		v.Set(newNumber())
-	case reflect.Map:
-		if v.IsNil() {
-			newMap()
-			return nil
+		value, err := n.value()
+		if err != nil {
+			return err
 		}
-		v.SetMapIndex(reflect.ValueOf(key), newNumber())
-	case reflect.Struct:
-		value := getDictStructTarget(v, key, reflect.TypeOf(0))
-		return setNumber(n, "", value)
+		v.Set(value)
+	case reflect.Ptr:
+		return setNumber(n, indirect(v))
 	default:
-		return fmt.Errorf("can not set number value to variable of type %s", v.Type().String())
+		return &UnmarshalTypeError{Value: "number", Type: v.Type()}
 	}
 	return nil
 }
 
-func setString(str, key string, v reflect.Value) error {
+func setString(str string, v reflect.Value) error {
 	if v.Type() == notfoundType {
 		return nil
 	}
-	newString := func() reflect.Value {
-		value := reflect.New(reflect.TypeOf(""))
-		value.Elem().SetString(str)
-		return value.Elem()
-	}
-	newMap := func() {
-		value := reflect.MakeMap(reflect.TypeOf(map[string]interface{}{}))
-		value.SetMapIndex(reflect.ValueOf(key), newString())
-		v.Set(value)
-	}
 	switch v.Kind() {
 	case reflect.String:
 		v.SetString(str)
 	case reflect.Slice:
 		if v.Type().Elem().Kind() != reflect.Uint8 {
-			return fmt.Errorf("can not set string value to variable of type: %s", v.Type().String())
+			return &UnmarshalTypeError{Value: "string", Type: v.Type()}
 		}
 		v.SetBytes([]byte(str))
 	case reflect.Array:
 		if v.Type().Elem().Kind() != reflect.Uint8 {
-			return fmt.Errorf("can not set string value to variable of type: %s", v.Type().String())
+			return &UnmarshalTypeError{Value: "string", Type: v.Type()}
 		}
-		data := []byte(str)
-		n := len(data)
-		if n > v.Len() {
-			n = v.Len()
+		// a string of other length is not the value of array, such
+		// as a truncated hash
+		if len(str) != v.Len() {
+			return &UnmarshalTypeError{Value: "string of length " + strconv.Itoa(len(str)), Type: v.Type()}
 		}
-		for i := 0; i < n; i++ {
-			v.Index(i).SetUint(uint64(data[i]))
+		for i := 0; i < len(str); i++ {
+			v.Index(i).SetUint(uint64(str[i]))
 		}
 	case reflect.Interface:
-		if len(key) > 0 {
-			if v.IsNil() {
-				newMap()
-				return nil
-			}
-			return setString(str, key, v.Elem())
-		}
-		if v.IsNil() {
-			v.Set(newString())
-			return nil
-		} else if v.Elem().Kind() != reflect.String {
-			v.Set(newString())
-			return nil
+		if v.NumMethod() > 0 {
+			return &UnmarshalTypeError{Value: "string", Type: v.Type()}
 		}
-		v.Elem().SetString(str)
-	case reflect.Map:
-		if v.IsNil() {
-			newMap()
-			return nil
-		}
-		v.SetMapIndex(reflect.ValueOf(key), newString())
-	case reflect.Struct:
-		value := getDictStructTarget(v, key, reflect.TypeOf(""))
-		return setString(str, "", value)
+		v.Set(reflect.ValueOf(str))
+	case reflect.Ptr:
+		return setString(str, indirect(v))
 	default:
-		return fmt.Errorf("can not set string value to variable of type %s", v.Type().String())
+		return &UnmarshalTypeError{Value: "string", Type: v.Type()}
 	}
 	return nil
 }
 
 func getDictStructTarget(v reflect.Value, key string, notfound reflect.Type) reflect.Value {
-	t := v.Type()
-	for i := 0; i < t.NumField(); i++ {
-		kField := t.Field(i)
-		if kField.Tag.Get("bencode") == key {
-			return v.Field(i)
-		}
+	index, ok := cachedFields(v.Type()).byKey[key]
+	if !ok {
+		return reflect.New(notfound).Elem()
 	}
-	for i := 0; i < t.NumField(); i++ {
-		kField := t.Field(i)
-		if strings.ToLower(kField.Name) == key {
-			return v.Field(i)
-		}
-		if kField.Anonymous {
-			vField := v.Field(i)
-			return getDictStructTarget(vField, key, notfound)
+	for i, idx := range index {
+		if i > 0 && v.Kind() == reflect.Ptr { // embedded pointer
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.New(notfound).Elem()
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
 		}
+		v = v.Field(idx)
 	}
-	return reflect.New(notfound).Elem()
-}
-
-func appendNumber(n number, slice reflect.Value) (reflect.Value, error) {
-	v := reflect.New(reflect.TypeOf(0))
-	v.Elem().SetInt(n.signed)
-	return reflect.Append(slice, v.Elem()), nil
+	return v
 }
 
-func appendString(str string, slice reflect.Value) (reflect.Value, error) {
-	v := reflect.New(reflect.TypeOf(""))
-	v.Elem().SetString(str)
-	return reflect.Append(slice, v.Elem()), nil
+// isMapKeyType reports whether dict keys can be decoded into the map
+// key of type t, it must be string kind or implement TextUnmarshaler
+// or BinaryUnmarshaler
+func isMapKeyType(t reflect.Type) bool {
+	return t.Kind() == reflect.String || isStringUnmarshalerType(reflect.PtrTo(t))
 }
 
-func appendDict(r io.Reader, slice reflect.Value) (reflect.Value, error) {
-	v := reflect.New(slice.Type().Elem())
-	err := decodeDict(r, v.Elem())
-	if err != nil {
-		return slice, err
+// mapKey returns the map key of type t from dict key
+func mapKey(t reflect.Type, key string) (reflect.Value, error) {
+	v := reflect.New(t)
+	if u := getStringUnmarshaler(v); u != nil {
+		return v.Elem(), u([]byte(key))
 	}
-	return reflect.Append(slice, v.Elem()), nil
+	return reflect.ValueOf(key).Convert(t), nil
 }
 
-func appendList(r io.Reader, slice reflect.Value) (reflect.Value, error) {
-	return slice, errors.New("not supported list in list")
+func isStringUnmarshalerType(t reflect.Type) bool {
+	return t.Implements(textUnmarshalerType) || t.Implements(binaryUnmarshalerType)
 }
 
-func convertInt(v reflect.Value, t reflect.Type) reflect.Value {
-	var ret reflect.Value
-	switch t.Kind() {
-	case reflect.Int, reflect.Interface:
-		return v
-	case reflect.Int8:
-		ret = reflect.New(reflect.TypeOf(int8(0)))
-		ret.Elem().SetInt(v.Int())
-	case reflect.Int16:
-		ret = reflect.New(reflect.TypeOf(int16(0)))
-		ret.Elem().SetInt(v.Int())
-	case reflect.Int32:
-		ret = reflect.New(reflect.TypeOf(int32(0)))
-		ret.Elem().SetInt(v.Int())
-	case reflect.Int64:
-		ret = reflect.New(reflect.TypeOf(int64(0)))
-		ret.Elem().SetInt(v.Int())
-	case reflect.Uint:
-		ret = reflect.New(reflect.TypeOf(uint(0)))
-		ret.Elem().SetUint(uint64(v.Int()))
-	case reflect.Uint8:
-		ret = reflect.New(reflect.TypeOf(uint8(0)))
-		ret.Elem().SetUint(uint64(v.Int()))
-	case reflect.Uint16:
-		ret = reflect.New(reflect.TypeOf(uint16(0)))
-		ret.Elem().SetUint(uint64(v.Int()))
-	case reflect.Uint32:
-		ret = reflect.New(reflect.TypeOf(uint32(0)))
-		ret.Elem().SetUint(uint64(v.Int()))
-	case reflect.Uint64:
-		ret = reflect.New(reflect.TypeOf(uint64(0)))
-		ret.Elem().SetUint(uint64(v.Int()))
+// getStringUnmarshaler returns UnmarshalText or UnmarshalBinary of v,
+// big.Int is excluded since it is decoded from number
+func getStringUnmarshaler(v reflect.Value) func([]byte) error {
+	if !v.IsValid() || v.Type() == notfoundType ||
+		v.Type() == bigIntType || v.Type() == reflect.PtrTo(bigIntType) {
+		return nil
 	}
-	return ret.Elem()
-}
-
-func convertString(v reflect.Value, t reflect.Type) (reflect.Value, error) {
-	var ret reflect.Value
-	switch t.Kind() {
-	case reflect.String, reflect.Interface:
-		return v, nil
-	case reflect.Slice:
-		if t.Elem().Kind() != reflect.Uint8 {
-			return ret, fmt.Errorf("can not set string value to variable of type %s", t.String())
-		}
-		str := []byte(v.String())
-		ret = reflect.MakeSlice(t, len(str), len(str))
-		for i, ch := range str {
-			ret.Index(i).SetUint(uint64(ch))
-		}
-	case reflect.Array:
-		if t.Elem().Kind() != reflect.Uint8 {
-			return ret, fmt.Errorf("can not set string value to variable of type %s", t.String())
-		}
-		str := []byte(v.String())
-		n := len(str)
-		if n > v.Len() {
-			n = v.Len()
-		}
-		ret = v
-		for i := 0; i < n; i++ {
-
+	var p reflect.Value
+	switch {
+	case v.Kind() == reflect.Ptr && isStringUnmarshalerType(v.Type()):
+		if v.IsNil() {
+			if !v.CanSet() {
+				return nil
+			}
+			v.Set(reflect.New(v.Type().Elem()))
 		}
+		p = v
+	case v.CanAddr() && isStringUnmarshalerType(v.Addr().Type()):
+		p = v.Addr()
+	default:
+		return nil
 	}
-	return ret, nil
-}
-
-func setList(v reflect.Value, slice reflect.Value) error {
-	if v.Type() == notfoundType {
+	if !p.CanInterface() {
 		return nil
 	}
-	if slice.Len() == 0 {
+	switch u := p.Interface().(type) {
+	case encoding.TextUnmarshaler:
+		return u.UnmarshalText
+	case encoding.BinaryUnmarshaler:
+		return u.UnmarshalBinary
+	}
+	return nil
+}
+
+func getUnmarshaler(v reflect.Value) Unmarshaler {
+	if !v.IsValid() || v.Type() == notfoundType {
 		return nil
 	}
-	switch v.Kind() {
-	case reflect.Slice:
-		if v.Type() == slice.Type() {
-			v.Set(slice)
-			return nil
-		}
-		value := reflect.MakeSlice(v.Type(), 0, 0)
-		switch reflect.TypeOf(slice.Index(0).Interface()).Kind() {
-		case reflect.Int:
-			for i := 0; i < slice.Len(); i++ {
-				iv := reflect.ValueOf(slice.Index(i).Interface())
-				n := convertInt(iv, v.Type().Elem())
-				value = reflect.Append(value, n)
-			}
-		case reflect.String:
-			for i := 0; i < slice.Len(); i++ {
-				iv := reflect.ValueOf(slice.Index(i).Interface())
-				s, err := convertString(iv, v.Type().Elem())
-				if err != nil {
-					return err
-				}
-				value = reflect.Append(value, s)
-			}
-		}
-		v.Set(value)
-	case reflect.Array:
-		_len := slice.Len()
-		if _len > v.Len() {
-			_len = v.Len()
-		}
-		switch reflect.TypeOf(slice.Index(0).Interface()).Kind() {
-		case reflect.Int:
-			for i := 0; i < _len; i++ {
-				iv := reflect.ValueOf(slice.Index(i).Interface())
-				n := convertInt(iv, v.Type().Elem())
-				v.Index(i).Set(n)
-			}
-		case reflect.String:
-			if v.Type().Elem().Kind() == reflect.Array {
-				// [m][n]byte
-				if v.Type().Elem().Elem().Kind() != reflect.Uint8 {
-					return fmt.Errorf("can not set string value to variable of type %s", v.Type().Elem().String())
-				}
-				for i := 0; i < _len; i++ {
-					target := v.Index(i)
-					vi := reflect.ValueOf(slice.Index(i).Interface())
-					str := []byte(vi.String())
-					n := len(str)
-					if n > target.Len() {
-						n = target.Len()
-					}
-					for j := 0; j < n; j++ {
-						target.Index(j).SetUint(uint64(str[j]))
-					}
-				}
+	if v.Kind() == reflect.Ptr && v.Type().Implements(unmarshalerType) {
+		if v.IsNil() {
+			if !v.CanSet() {
 				return nil
 			}
-			for i := 0; i < _len; i++ {
-				iv := reflect.ValueOf(slice.Index(i).Interface())
-				s, err := convertString(iv, v.Type().Elem())
-				if err != nil {
-					return err
-				}
-				v.Index(i).Set(s)
-			}
+			v.Set(reflect.New(v.Type().Elem()))
 		}
-	case reflect.Interface:
-		v.Set(slice)
-	default:
-		return fmt.Errorf("can not set list value to variable of type %s", v.Type().String())
+		return v.Interface().(Unmarshaler)
+	}
+	if v.CanAddr() && v.Addr().CanInterface() &&
+		v.Addr().Type().Implements(unmarshalerType) {
+		return v.Addr().Interface().(Unmarshaler)
 	}
 	return nil
 }
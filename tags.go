@@ -0,0 +1,90 @@
+package bencode
+
+import (
+	"reflect"
+	"strings"
+)
+
+// tagOptions is the string following a comma in a bencode tag
+type tagOptions string
+
+// parseTag splits a bencode tag into its key name and options
+func parseTag(tag string) (string, tagOptions) {
+	if idx := strings.Index(tag, ","); idx != -1 {
+		return tag[:idx], tagOptions(tag[idx+1:])
+	}
+	return tag, ""
+}
+
+// Contains reports whether opts contains the given option
+func (opts tagOptions) Contains(name string) bool {
+	s := string(opts)
+	for s != "" {
+		var next string
+		if idx := strings.Index(s, ","); idx >= 0 {
+			s, next = s[:idx], s[idx+1:]
+		}
+		if s == name {
+			return true
+		}
+		s = next
+	}
+	return false
+}
+
+// fieldKey returns the dict key of struct field and its options,
+// skip is true when the field is ignored by "-" tag
+func fieldKey(field reflect.StructField) (key string, opts tagOptions, skip bool) {
+	tag := field.Tag.Get("bencode")
+	if tag == "-" {
+		return "", "", true
+	}
+	key, opts = parseTag(tag)
+	if len(key) == 0 {
+		key = strings.ToLower(field.Name)
+	}
+	return key, opts, false
+}
+
+// isEmbedded reports whether the fields of struct field are promoted
+// into its parent dict
+func isEmbedded(field reflect.StructField) bool {
+	if !field.Anonymous {
+		return false
+	}
+	tag := field.Tag.Get("bencode")
+	if tag == "-" {
+		return false
+	}
+	if name, _ := parseTag(tag); len(name) > 0 {
+		return false
+	}
+	t := field.Type
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct
+}
+
+// isVisible reports whether the struct field is encoded as dict key
+func isVisible(field reflect.StructField) bool {
+	return len(field.PkgPath) == 0 && !isEmbedded(field)
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
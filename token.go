@@ -0,0 +1,181 @@
+package bencode
+
+import (
+	"math/big"
+	"strconv"
+)
+
+// Token holds a value of one of these types:
+//
+//	Delim, for the start of dict or list and the end of them
+//	Int, for bencode numbers
+//	String, for bencode strings
+type Token interface{}
+
+// Delim is the delimiter of dict and list
+type Delim byte
+
+// delimiters of dict and list
+const (
+	DictStart Delim = 'd'
+	ListStart Delim = 'l'
+	End       Delim = 'e'
+)
+
+func (d Delim) String() string {
+	return string(d)
+}
+
+func (d Delim) what() string {
+	if d == DictStart {
+		return "dict"
+	}
+	return "list"
+}
+
+// Int is the decimal text of a bencode number
+type Int string
+
+// Int64 returns the number as int64
+func (n Int) Int64() (int64, error) {
+	return strconv.ParseInt(string(n), 10, 64)
+}
+
+// Uint64 returns the number as uint64
+func (n Int) Uint64() (uint64, error) {
+	return strconv.ParseUint(string(n), 10, 64)
+}
+
+// BigInt returns the number as *big.Int
+func (n Int) BigInt() *big.Int {
+	ret, _ := new(big.Int).SetString(string(n), 10)
+	return ret
+}
+
+// String is a bencode byte string
+type String []byte
+
+type tokenState struct {
+	kind Delim
+	n    int    // count of tokens in dict or list
+	prev string // previous key of dict
+}
+
+// expectKey reports whether the next token is a dict key
+func (dec Decoder) expectKey() bool {
+	if len(dec.tokens) == 0 {
+		return false
+	}
+	top := dec.tokens[len(dec.tokens)-1]
+	return top.kind == DictStart && top.n%2 == 0
+}
+
+// valueDone is called when a whole value is read
+func (dec Decoder) valueDone() {
+	if len(dec.tokens) > 0 {
+		dec.tokens[len(dec.tokens)-1].n++
+	}
+}
+
+// Token returns the next bencode token in the input stream,
+// at the end of the input stream, Token returns nil, io.EOF.
+//
+// Token can be mixed with Decode and Skip, for example use Token to
+// find the info dict and then Decode it into a struct.
+func (dec Decoder) Token() (Token, error) {
+	d := dec.state()
+	defer func() {
+		dec.offset = d.offset
+	}()
+	ch, err := d.readByte()
+	if err != nil {
+		if len(dec.tokens) > 0 {
+			return nil, d.readError(dec.tokens[len(dec.tokens)-1].kind.what(), err)
+		}
+		return nil, err
+	}
+	switch ch {
+	case 'e':
+		if len(dec.tokens) == 0 {
+			return nil, d.syntaxError("unexpected end of dict or list")
+		}
+		top := dec.tokens[len(dec.tokens)-1]
+		if top.kind == DictStart && top.n%2 == 1 {
+			return nil, d.syntaxError("missing value of dict key %q", top.prev)
+		}
+		dec.tokens = dec.tokens[:len(dec.tokens)-1]
+		dec.valueDone()
+		return End, nil
+	case 'd', 'l':
+		if dec.expectKey() {
+			return nil, d.syntaxError("dict key is not a string")
+		}
+		if dec.maxDepth > 0 && len(dec.tokens) >= dec.maxDepth {
+			return nil, d.limitError("depth", int64(dec.maxDepth))
+		}
+		dec.tokens = append(dec.tokens, tokenState{kind: Delim(ch)})
+		return Delim(ch), nil
+	case 'i':
+		if dec.expectKey() {
+			return nil, d.syntaxError("dict key is not a string")
+		}
+		n, err := parseNumber(d)
+		if err != nil {
+			return nil, err
+		}
+		dec.valueDone()
+		return Int(n.bigInt().String()), nil
+	default:
+		str, err := parseString(d, ch)
+		if err != nil {
+			return nil, err
+		}
+		if dec.expectKey() {
+			top := &dec.tokens[len(dec.tokens)-1]
+			if top.n > 0 {
+				err = checkDictKey(d, top.prev, str)
+				if err != nil {
+					return nil, err
+				}
+			}
+			top.prev = str
+		}
+		dec.valueDone()
+		return String(str), nil
+	}
+}
+
+// More reports whether there is another element in the current dict or list
+func (dec Decoder) More() bool {
+	if dec.r == nil {
+		return dec.offset < int64(len(dec.data)) && dec.data[dec.offset] != 'e'
+	}
+	ch, err := dec.r.ReadByte()
+	if err != nil {
+		return false
+	}
+	dec.r.UnreadByte()
+	return ch != 'e'
+}
+
+// Skip read and discard the next value, when the next value is a dict
+// or list, all of its elements are skipped
+func (dec Decoder) Skip() error {
+	d := dec.state()
+	defer func() {
+		dec.offset = d.offset
+	}()
+	ch, err := d.readByte()
+	if err != nil {
+		return err
+	}
+	if ch == 'e' {
+		return d.syntaxError("unexpected end of dict or list")
+	}
+	err = skipValue(d, ch)
+	if err != nil {
+		return err
+	}
+	dec.valueDone()
+	return nil
+}
@@ -0,0 +1,100 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestToken(t *testing.T) {
+	str := []byte("d1:ai-1e1:bl3:abcdee1:ci123456789012345678901234567890ee")
+	dec := NewDecoder(bytes.NewReader(str))
+	var tokens []string
+	for {
+		tk, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("FATAL: read token: %v", err)
+		}
+		switch v := tk.(type) {
+		case Delim:
+			tokens = append(tokens, v.String())
+		case Int:
+			tokens = append(tokens, "i"+string(v))
+		case String:
+			tokens = append(tokens, "s"+string(v))
+		default:
+			t.Fatalf("unexpected token type: %T", tk)
+		}
+	}
+	target := "[d sa i-1 sb l sabc d e e sc i123456789012345678901234567890 e]"
+	if fmt.Sprint(tokens) != target {
+		t.Fatalf("unexpected tokens: %v", tokens)
+	}
+	if dec.InputOffset() != int64(len(str)) {
+		t.Fatalf("unexpected offset: %d", dec.InputOffset())
+	}
+
+	invalid := []string{"e", "di1ei2ee", "d1:ae", "d1:bi1e1:ai2ee", "li1e"}
+	for _, str := range invalid {
+		dec := NewDecoder(bytes.NewReader([]byte(str)))
+		var err error
+		for err == nil {
+			_, err = dec.Token()
+		}
+		var e *SyntaxError
+		if !errors.As(err, &e) {
+			t.Fatalf("unexpected error of %s: %v", str, err)
+		}
+	}
+}
+
+func TestTokenFindInfo(t *testing.T) {
+	str := []byte("d8:announce3:abc7:comment3:def4:infod6:lengthi1e4:name1:aee")
+	dec := NewDecoder(bytes.NewReader(str))
+	tk, err := dec.Token()
+	if err != nil || tk != DictStart {
+		t.Fatalf("unexpected first token: %v %v", tk, err)
+	}
+	var info struct {
+		Length int    `bencode:"length"`
+		Name   string `bencode:"name"`
+	}
+	found := false
+	for dec.More() {
+		tk, err = dec.Token()
+		if err != nil {
+			t.Fatalf("FATAL: read key: %v", err)
+		}
+		if string(tk.(String)) != "info" {
+			err = dec.Skip()
+			if err != nil {
+				t.Fatalf("FATAL: skip value of %s: %v", string(tk.(String)), err)
+			}
+			continue
+		}
+		err = dec.Decode(&info)
+		if err != nil {
+			t.Fatalf("FATAL: decode info: %v", err)
+		}
+		found = true
+	}
+	if !found {
+		t.Fatal("info not found")
+	}
+	if info.Length != 1 || info.Name != "a" {
+		t.Fatalf("unexpected info value: %v", info)
+	}
+	tk, err = dec.Token()
+	if err != nil || tk != End {
+		t.Fatalf("unexpected last token: %v %v", tk, err)
+	}
+	_, err = dec.Token()
+	if err != io.EOF {
+		t.Fatalf("unexpected error at the end: %v", err)
+	}
+}
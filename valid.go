@@ -0,0 +1,74 @@
+package bencode
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+// Valid reports whether data is exactly one canonical bencode value,
+// the syntax is checked without decoding into Go values
+func Valid(data []byte) bool {
+	d := &decodeState{data: data, strict: true}
+	ch, err := d.readByte()
+	if err != nil {
+		return false
+	}
+	err = skipValue(d, ch)
+	return err == nil && d.offset == int64(len(data))
+}
+
+// Canonicalize appends the canonical encoding of src to dst, src may be
+// non-canonical, such as unsorted dict keys or leading zeros in numbers
+// and string sizes, but duplicate dict keys are rejected
+func Canonicalize(dst *bytes.Buffer, src []byte) error {
+	var v Value
+	dec := NewBytesDecoder(src)
+	dec.AllowNonCanonical()
+	err := dec.Decode(&v)
+	if err != nil {
+		return err
+	}
+	err = dec.endError(len(src))
+	if err != nil {
+		return err
+	}
+	err = canonicalize(&v)
+	if err != nil {
+		return err
+	}
+	return v.encode(dst)
+}
+
+func canonicalize(v *Value) error {
+	switch v.kind {
+	case IntKind:
+		n, ok := new(big.Int).SetString(v.num, 10)
+		if !ok {
+			return fmt.Errorf("invalid number: %s", v.num)
+		}
+		v.num = n.String()
+	case ListKind:
+		for i := range v.list {
+			err := canonicalize(&v.list[i])
+			if err != nil {
+				return err
+			}
+		}
+	case DictKind:
+		sort.SliceStable(v.dict, func(i, j int) bool {
+			return v.dict[i].Key < v.dict[j].Key
+		})
+		for i := range v.dict {
+			if i > 0 && v.dict[i].Key == v.dict[i-1].Key {
+				return fmt.Errorf("duplicate dict key: %q", v.dict[i].Key)
+			}
+			err := canonicalize(&v.dict[i].Value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestValid(t *testing.T) {
+	for _, str := range []string{"i0e", "i-1e", "0:", "3:abc", "le", "de", "d1:ai1e1:bl1:cee"} {
+		if !Valid([]byte(str)) {
+			t.Fatalf("unexpected invalid of %q", str)
+		}
+	}
+	for _, str := range []string{"", "i01e", "i-0e", "ie", "03:abc", "4:abc", "l", "d1:bi1e1:ai2ee",
+		"d1:ai1e1:ai2ee", "di1ei2ee", "i1ei2e", "x"} {
+		if Valid([]byte(str)) {
+			t.Fatalf("unexpected valid of %q", str)
+		}
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	run := func(str, want string) {
+		var buf bytes.Buffer
+		buf.WriteString("x")
+		err := Canonicalize(&buf, []byte(str))
+		if err != nil {
+			t.Fatalf("FATAL: canonicalize %q: %v", str, err)
+		}
+		if buf.String() != "x"+want {
+			t.Fatalf("unexpected canonical of %q: %q", str, buf.String())
+		}
+		if !Valid(buf.Bytes()[1:]) {
+			t.Fatalf("invalid canonical of %q", str)
+		}
+	}
+	run("i007e", "i7e")
+	run("i-0e", "i0e")
+	run("i-012345678901234567890123e", "i-12345678901234567890123e")
+	run("03:abc", "3:abc")
+	run("d1:bi1e1:ad1:zi0e1:yi01eee", "d1:ad1:yi1e1:zi0ee1:bi1ee")
+	run("ld1:b0:1:a0:ee", "ld1:a0:1:b0:ee")
+
+	for _, str := range []string{"d1:ai1e1:ai2ee", "d1:bd1:ai1e1:ai1eee", "i1ei2e", "ie", "i1x2e"} {
+		var buf bytes.Buffer
+		if Canonicalize(&buf, []byte(str)) == nil {
+			t.Fatalf("expected error of %q", str)
+		}
+	}
+}
+
+func TestValidDeep(t *testing.T) {
+	deep := bytes.Repeat([]byte("l"), 20<<20)
+	if Valid(deep) {
+		t.Fatalf("unexpected valid of deep list")
+	}
+	var buf bytes.Buffer
+	err := Canonicalize(&buf, deep)
+	var e *LimitError
+	if !errors.As(err, &e) || e.Limit != "depth" {
+		t.Fatalf("unexpected error of deep list: %v", err)
+	}
+	nested := append(bytes.Repeat([]byte("l"), maxNestingDepth), bytes.Repeat([]byte("e"), maxNestingDepth)...)
+	if !Valid(nested) {
+		t.Fatalf("unexpected invalid of list nested %d", maxNestingDepth)
+	}
+}
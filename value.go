@@ -0,0 +1,353 @@
+package bencode
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"reflect"
+	"strconv"
+)
+
+// Kind is the kind of bencode Value
+type Kind int
+
+// kinds of bencode Value
+const (
+	InvalidKind Kind = iota
+	IntKind
+	StringKind
+	ListKind
+	DictKind
+)
+
+var kindNames = []string{
+	InvalidKind: "invalid",
+	IntKind:     "int",
+	StringKind:  "string",
+	ListKind:    "list",
+	DictKind:    "dict",
+}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return "kind" + strconv.Itoa(int(k))
+}
+
+var valueType = reflect.TypeOf(Value{})
+
+// Value is a generic bencode value, the order of dict entries is kept,
+// so a decoded Value is encoded back into the same bytes
+type Value struct {
+	kind Kind
+	num  string // decimal text of number
+	str  []byte
+	list []Value
+	dict []DictEntry
+}
+
+// DictEntry is a key and value pair in dict
+type DictEntry struct {
+	Key   string
+	Value Value
+}
+
+// NewInt create a number Value
+func NewInt(n int64) Value {
+	return Value{kind: IntKind, num: strconv.FormatInt(n, 10)}
+}
+
+// NewBigInt create a number Value from *big.Int
+func NewBigInt(n *big.Int) Value {
+	return Value{kind: IntKind, num: n.String()}
+}
+
+// NewString create a string Value
+func NewString(str string) Value {
+	return Value{kind: StringKind, str: []byte(str)}
+}
+
+// NewBytes create a string Value from bytes
+func NewBytes(data []byte) Value {
+	return Value{kind: StringKind, str: data}
+}
+
+// NewList create a list Value
+func NewList(items ...Value) Value {
+	return Value{kind: ListKind, list: items}
+}
+
+// NewDict create a dict Value, the entries are kept in the given order
+func NewDict(entries ...DictEntry) Value {
+	return Value{kind: DictKind, dict: entries}
+}
+
+// Kind returns the kind of v
+func (v Value) Kind() Kind {
+	return v.kind
+}
+
+// IsValid reports whether v is a value, Get and Index returns
+// invalid Value when the key or index is not found
+func (v Value) IsValid() bool {
+	return v.kind != InvalidKind
+}
+
+// Int returns the number of v, it returns 0 when v is not a number
+// or overflows int64
+func (v Value) Int() int64 {
+	if v.kind != IntKind {
+		return 0
+	}
+	n, _ := strconv.ParseInt(v.num, 10, 64)
+	return n
+}
+
+// BigInt returns the number of v, it returns nil when v is not a number
+func (v Value) BigInt() *big.Int {
+	if v.kind != IntKind {
+		return nil
+	}
+	n, _ := new(big.Int).SetString(v.num, 10)
+	return n
+}
+
+// String returns the string of v, it returns "" when v is not a string
+func (v Value) String() string {
+	if v.kind != StringKind {
+		return ""
+	}
+	return string(v.str)
+}
+
+// Bytes returns the string of v as bytes, it returns nil when v is not a string
+func (v Value) Bytes() []byte {
+	if v.kind != StringKind {
+		return nil
+	}
+	return v.str
+}
+
+// Len returns the count of elements in list or entries in dict
+func (v Value) Len() int {
+	switch v.kind {
+	case ListKind:
+		return len(v.list)
+	case DictKind:
+		return len(v.dict)
+	}
+	return 0
+}
+
+// Index returns the i'th element of list
+func (v Value) Index(i int) Value {
+	if v.kind != ListKind || i < 0 || i >= len(v.list) {
+		return Value{}
+	}
+	return v.list[i]
+}
+
+// List returns the elements of list
+func (v Value) List() []Value {
+	if v.kind != ListKind {
+		return nil
+	}
+	return v.list
+}
+
+// Get returns the value of key in dict
+func (v Value) Get(key string) Value {
+	if v.kind != DictKind {
+		return Value{}
+	}
+	for _, entry := range v.dict {
+		if entry.Key == key {
+			return entry.Value
+		}
+	}
+	return Value{}
+}
+
+// Entries returns the entries of dict in order
+func (v Value) Entries() []DictEntry {
+	if v.kind != DictKind {
+		return nil
+	}
+	return v.dict
+}
+
+// Keys returns the keys of dict in order
+func (v Value) Keys() []string {
+	if v.kind != DictKind {
+		return nil
+	}
+	keys := make([]string, len(v.dict))
+	for i, entry := range v.dict {
+		keys[i] = entry.Key
+	}
+	return keys
+}
+
+// Set sets the value of key in dict, a new key is appended to the end
+func (v *Value) Set(key string, value Value) error {
+	if v.kind != DictKind {
+		return errors.New("set key on " + v.kind.String() + " value")
+	}
+	for i := range v.dict {
+		if v.dict[i].Key == key {
+			v.dict[i].Value = value
+			return nil
+		}
+	}
+	v.dict = append(v.dict, DictEntry{Key: key, Value: value})
+	return nil
+}
+
+// Append appends values to list
+func (v *Value) Append(values ...Value) error {
+	if v.kind != ListKind {
+		return errors.New("append on " + v.kind.String() + " value")
+	}
+	v.list = append(v.list, values...)
+	return nil
+}
+
+// MarshalBencode encode v in the order of its dict entries
+func (v Value) MarshalBencode() ([]byte, error) {
+	var buf bytes.Buffer
+	err := v.encode(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (v Value) encode(buf *bytes.Buffer) error {
+	switch v.kind {
+	case IntKind:
+		buf.WriteString("i" + v.num + "e")
+	case StringKind:
+		buf.WriteString(strconv.Itoa(len(v.str)) + ":")
+		buf.Write(v.str)
+	case ListKind:
+		buf.WriteByte('l')
+		for _, item := range v.list {
+			err := item.encode(buf)
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	case DictKind:
+		buf.WriteByte('d')
+		for _, entry := range v.dict {
+			buf.WriteString(strconv.Itoa(len(entry.Key)) + ":" + entry.Key)
+			err := entry.Value.encode(buf)
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	default:
+		return errors.New("encode invalid value")
+	}
+	return nil
+}
+
+// UnmarshalBencode decode data into v, the order of dict entries is kept,
+// data must be exactly one canonical value, decode it by a Decoder with
+// AllowNonCanonical to accept non-canonical data
+func (v *Value) UnmarshalBencode(data []byte) error {
+	d := &decodeState{data: data, strict: true}
+	ch, err := d.readByte()
+	if err != nil {
+		return err
+	}
+	value, err := parseValue(d, ch)
+	if err != nil {
+		return err
+	}
+	if d.offset != int64(len(data)) {
+		return d.syntaxError("unexpected data after value")
+	}
+	*v = value
+	return nil
+}
+
+// parseValue parse the value started with ch
+func parseValue(d *decodeState, ch byte) (Value, error) {
+	switch ch {
+	case 'i':
+		n, err := parseNumber(d)
+		if err != nil {
+			return Value{}, err
+		}
+		return Value{kind: IntKind, num: n.bigInt().String()}, nil
+	case 'd', 'l':
+		err := d.enter()
+		if err != nil {
+			return Value{}, err
+		}
+		defer d.leave()
+		ret := Value{kind: ListKind}
+		what := "list"
+		if ch == 'd' {
+			ret.kind = DictKind
+			what = "dict"
+		}
+		for {
+			next, err := d.readByte()
+			if err != nil {
+				return ret, d.readError(what, err)
+			}
+			if next == 'e' {
+				return ret, nil
+			}
+			err = d.addElement()
+			if err != nil {
+				return ret, err
+			}
+			if ret.kind == ListKind {
+				item, err := parseValue(d, next)
+				if err != nil {
+					return ret, err
+				}
+				ret.list = append(ret.list, item)
+				continue
+			}
+			key, err := parseString(d, next)
+			if err != nil {
+				return ret, err
+			}
+			if len(ret.dict) > 0 {
+				err = checkDictKey(d, ret.dict[len(ret.dict)-1].Key, key)
+				if err != nil {
+					return ret, err
+				}
+			}
+			next, err = d.readByte()
+			if err != nil {
+				return ret, d.readError("dict", err)
+			}
+			item, err := parseValue(d, next)
+			if err != nil {
+				return ret, err
+			}
+			ret.dict = append(ret.dict, DictEntry{Key: key, Value: item})
+		}
+	default:
+		size, err := parseStringSize(d, ch)
+		if err != nil {
+			return Value{}, err
+		}
+		data, err := readString(d, size)
+		if err != nil {
+			return Value{}, d.readError("string", err)
+		}
+		if d.r == nil && !d.noCopy {
+			data = append(make([]byte, 0, len(data)), data...)
+		}
+		return Value{kind: StringKind, str: data}, nil
+	}
+}
@@ -0,0 +1,113 @@
+package bencode
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestValue(t *testing.T) {
+	str := []byte("d8:announce3:abc4:infod6:lengthi1e4:name1:a6:pieces2:\x00\x01e4:listli1el1:bee3:numi123456789012345678901234567890ee")
+	var v Value
+	err := Decode(str, &v)
+	if err != nil {
+		t.Fatalf("FATAL: decode value: %v", err)
+	}
+	if v.Kind() != DictKind {
+		t.Fatalf("unexpected kind: %s", v.Kind())
+	}
+	if v.Get("info").Get("name").String() != "a" {
+		t.Fatalf("unexpected value of info.name: %s", v.Get("info").Get("name").String())
+	}
+	if v.Get("info").Get("length").Int() != 1 {
+		t.Fatalf("unexpected value of info.length: %d", v.Get("info").Get("length").Int())
+	}
+	if !bytes.Equal(v.Get("info").Get("pieces").Bytes(), []byte{0, 1}) {
+		t.Fatalf("unexpected value of info.pieces: %v", v.Get("info").Get("pieces").Bytes())
+	}
+	if v.Get("list").Index(1).Index(0).String() != "b" {
+		t.Fatalf("unexpected value of list[1][0]: %s", v.Get("list").Index(1).Index(0).String())
+	}
+	if v.Get("num").BigInt().String() != "123456789012345678901234567890" {
+		t.Fatalf("unexpected value of num: %s", v.Get("num").BigInt().String())
+	}
+	if v.Get("missing").IsValid() || v.Get("info").Get("name").Get("x").IsValid() {
+		t.Fatal("unexpected valid value of missing key")
+	}
+	data, err := Encode(v)
+	if err != nil {
+		t.Fatalf("FATAL: encode value: %v", err)
+	}
+	if !bytes.Equal(data, str) {
+		t.Fatalf("unexpected encoded value: %q", string(data))
+	}
+}
+
+func TestValueOrder(t *testing.T) {
+	// keys are not sorted, it is kept by Value
+	str := []byte("d1:bi1e1:ad1:zi1e1:yi2eee")
+	dec := NewDecoder(bytes.NewReader(str))
+	dec.AllowNonCanonical()
+	var v Value
+	err := dec.Decode(&v)
+	if err != nil {
+		t.Fatalf("FATAL: decode value: %v", err)
+	}
+	keys := v.Keys()
+	if len(keys) != 2 || keys[0] != "b" || keys[1] != "a" {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+	data, err := Encode(v)
+	if err != nil {
+		t.Fatalf("FATAL: encode value: %v", err)
+	}
+	if !bytes.Equal(data, str) {
+		t.Fatalf("unexpected encoded value: %s", string(data))
+	}
+
+	dict := NewDict()
+	dict.Set("name", NewString("a"))
+	dict.Set("files", NewList(NewInt(1)))
+	dict.Set("name", NewBytes([]byte("b")))
+	list := dict.Get("files")
+	list.Append(NewInt(-2))
+	dict.Set("files", list)
+	data, err = Encode(dict)
+	if err != nil {
+		t.Fatalf("FATAL: encode new value: %v", err)
+	}
+	if string(data) != "d4:name1:b5:filesli1ei-2eee" {
+		t.Fatalf("unexpected new value: %s", string(data))
+	}
+	err = list.Set("x", NewInt(1))
+	if err == nil {
+		t.Fatal("expected error on set key of list")
+	}
+}
+
+func TestValueStrict(t *testing.T) {
+	for _, str := range []string{"d1:bi1e1:ai2ee", "d1:ai1e1:ai2ee", "i01e", "03:abc", "i1etail", ""} {
+		var v Value
+		if v.UnmarshalBencode([]byte(str)) == nil {
+			t.Fatalf("expected error of %q", str)
+		}
+	}
+
+	// the options of Decoder are used instead of UnmarshalBencode
+	var v struct {
+		Info *Value `bencode:"info"`
+	}
+	dec := NewDecoder(bytes.NewReader([]byte("d4:infod1:bi01e1:ai2eee")))
+	dec.AllowNonCanonical()
+	err := dec.Decode(&v)
+	if err != nil {
+		t.Fatalf("FATAL: decode non-canonical: %v", err)
+	}
+	if v.Info == nil || v.Info.Get("b").Int() != 1 {
+		t.Fatalf("unexpected value: %v", v.Info)
+	}
+	dec = NewDecoder(bytes.NewReader([]byte("d4:infoli1ei2ei3eee")))
+	dec.SetMaxElements(2)
+	if _, ok := dec.Decode(&v).(*LimitError); !ok {
+		t.Fatal("expected limit error of elements")
+	}
+}
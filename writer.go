@@ -0,0 +1,123 @@
+package bencode
+
+import (
+	"errors"
+	"fmt"
+)
+
+type writeState struct {
+	kind       Delim
+	keyPending bool   // key is written and waiting for its value
+	prev       string // previous key of dict
+	n          int    // count of keys in dict
+}
+
+// RequireSortedKeys causes the Write methods to return an error when
+// the dict keys are not written in sorted order
+func (enc Encoder) RequireSortedKeys() {
+	enc.sortedKeys = true
+}
+
+// beginValue checks whether a value can be written at this position
+func (enc Encoder) beginValue() error {
+	if len(enc.tokens) == 0 {
+		return nil
+	}
+	top := &enc.tokens[len(enc.tokens)-1]
+	if top.kind != DictStart {
+		return nil
+	}
+	if !top.keyPending {
+		return errors.New("missing dict key before value")
+	}
+	top.keyPending = false
+	return nil
+}
+
+func (enc Encoder) write(str string) error {
+	_, err := enc.w.Write([]byte(str))
+	return err
+}
+
+// WriteDictStart starts a dict, it must be closed by WriteEnd
+func (enc Encoder) WriteDictStart() error {
+	err := enc.beginValue()
+	if err != nil {
+		return err
+	}
+	enc.tokens = append(enc.tokens, writeState{kind: DictStart})
+	return enc.write("d")
+}
+
+// WriteListStart starts a list, it must be closed by WriteEnd
+func (enc Encoder) WriteListStart() error {
+	err := enc.beginValue()
+	if err != nil {
+		return err
+	}
+	enc.tokens = append(enc.tokens, writeState{kind: ListStart})
+	return enc.write("l")
+}
+
+// WriteEnd closes the last dict or list
+func (enc Encoder) WriteEnd() error {
+	if len(enc.tokens) == 0 {
+		return errors.New("no dict or list to end")
+	}
+	top := enc.tokens[len(enc.tokens)-1]
+	if top.keyPending {
+		return fmt.Errorf("missing value of dict key %q", top.prev)
+	}
+	enc.tokens = enc.tokens[:len(enc.tokens)-1]
+	return enc.write("e")
+}
+
+// WriteKey writes the key of next dict value
+func (enc Encoder) WriteKey(key string) error {
+	if len(enc.tokens) == 0 || enc.tokens[len(enc.tokens)-1].kind != DictStart {
+		return errors.New("write key outside of dict")
+	}
+	top := &enc.tokens[len(enc.tokens)-1]
+	if top.keyPending {
+		return fmt.Errorf("missing value of dict key %q", top.prev)
+	}
+	if enc.sortedKeys && top.n > 0 && key <= top.prev {
+		return fmt.Errorf("dict key %q is not sorted after %q", key, top.prev)
+	}
+	top.keyPending = true
+	top.prev = key
+	top.n++
+	return enc.write(uint64Str(uint64(len(key))) + ":" + key)
+}
+
+// WriteInt writes a number
+func (enc Encoder) WriteInt(n int64) error {
+	err := enc.beginValue()
+	if err != nil {
+		return err
+	}
+	return enc.write("i" + int64Str(n) + "e")
+}
+
+// WriteString writes a string
+func (enc Encoder) WriteString(str string) error {
+	err := enc.beginValue()
+	if err != nil {
+		return err
+	}
+	return enc.write(uint64Str(uint64(len(str))) + ":" + str)
+}
+
+// WriteBytes writes a byte string
+func (enc Encoder) WriteBytes(data []byte) error {
+	err := enc.beginValue()
+	if err != nil {
+		return err
+	}
+	err = enc.write(uint64Str(uint64(len(data))) + ":")
+	if err != nil {
+		return err
+	}
+	_, err = enc.w.Write(data)
+	return err
+}
@@ -0,0 +1,99 @@
+package bencode
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.RequireSortedKeys()
+	steps := []func() error{
+		enc.WriteDictStart,
+		func() error { return enc.WriteKey("announce") },
+		func() error { return enc.WriteString("abc") },
+		func() error { return enc.WriteKey("info") },
+		enc.WriteDictStart,
+		func() error { return enc.WriteKey("files") },
+		enc.WriteListStart,
+		func() error { return enc.Encode(map[string]int{"length": 1}) },
+		enc.WriteEnd,
+		func() error { return enc.WriteKey("piece length") },
+		func() error { return enc.WriteInt(-1) },
+		func() error { return enc.WriteKey("pieces") },
+		func() error { return enc.WriteBytes([]byte{0, 1}) },
+		enc.WriteEnd,
+		enc.WriteEnd,
+	}
+	for i, step := range steps {
+		err := step()
+		if err != nil {
+			t.Fatalf("FATAL: step %d: %v", i, err)
+		}
+	}
+	target := "d8:announce3:abc4:infod5:filesld6:lengthi1eee12:piece lengthi-1e6:pieces2:\x00\x01ee"
+	if buf.String() != target {
+		t.Fatalf("unexpected value: %q", buf.String())
+	}
+}
+
+func TestWriterInvalid(t *testing.T) {
+	cases := map[string]func(enc Encoder) error{
+		"end without start": func(enc Encoder) error {
+			return enc.WriteEnd()
+		},
+		"key outside of dict": func(enc Encoder) error {
+			enc.WriteListStart()
+			return enc.WriteKey("a")
+		},
+		"value without key": func(enc Encoder) error {
+			enc.WriteDictStart()
+			return enc.WriteInt(1)
+		},
+		"encode without key": func(enc Encoder) error {
+			enc.WriteDictStart()
+			return enc.Encode(1)
+		},
+		"key without value": func(enc Encoder) error {
+			enc.WriteDictStart()
+			enc.WriteKey("a")
+			return enc.WriteKey("b")
+		},
+		"end without value": func(enc Encoder) error {
+			enc.WriteDictStart()
+			enc.WriteKey("a")
+			return enc.WriteEnd()
+		},
+		"unsorted keys": func(enc Encoder) error {
+			enc.RequireSortedKeys()
+			enc.WriteDictStart()
+			enc.WriteKey("b")
+			enc.WriteInt(1)
+			return enc.WriteKey("a")
+		},
+		"duplicate keys": func(enc Encoder) error {
+			enc.RequireSortedKeys()
+			enc.WriteDictStart()
+			enc.WriteKey("a")
+			enc.WriteInt(1)
+			return enc.WriteKey("a")
+		},
+	}
+	for name, run := range cases {
+		var buf bytes.Buffer
+		if run(NewEncoder(&buf)) == nil {
+			t.Fatalf("expected error on %s", name)
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.WriteDictStart()
+	enc.WriteKey("b")
+	enc.WriteInt(1)
+	err := enc.WriteKey("a")
+	if err != nil {
+		t.Fatalf("FATAL: write unsorted key without RequireSortedKeys: %v", err)
+	}
+}